@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The agent keeps the tokens of one or more profiles fresh (refreshing them ahead of expiry)
+// and hands them out over a Unix domain socket. The protocol is one JSON object per line in
+// each direction, i.e. an AgentRequest followed by an AgentResponse.
+//
+// 👉 "o2token get" is a thin client for the same protocol
+
+// How often the agent checks if any tokens are about to expire
+const agentCheckInterval = 30 * time.Second
+
+type AgentRequest struct {
+	Profile string `json:"profile"`
+}
+
+type AgentResponse struct {
+	Profile     string    `json:"profile"`
+	AccessToken string    `json:"access_token,omitempty"`
+	IDToken     string    `json:"id_token,omitempty"`
+	TokenType   string    `json:"token_type,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type Agent struct {
+	mutex          sync.Mutex // serializes all token operations (they share the global appConfig)
+	configs        map[string]AppConfig
+	defaultProfile string
+	refreshMargin  time.Duration
+}
+
+func agentCommand(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	profilesPtr := fs.String("profiles", parseStringEnvVar("", "O2TOKEN_AGENT_PROFILES"), "Profiles to keep tokens for (comma separated)")
+	refreshMarginPtr := fs.Duration("refresh-margin", 5*time.Minute, "Refresh tokens this long before they expire")
+	socketPtr := fs.String("socket", defaultAgentSocketPath(), "Unix domain socket to listen on")
+	fs.Parse(args)
+
+	profiles := strings.FieldsFunc(*profilesPtr, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(profiles) == 0 {
+		return fmt.Errorf("no profiles specified")
+	}
+
+	agent := &Agent{
+		configs:       map[string]AppConfig{},
+		refreshMargin: *refreshMarginPtr,
+	}
+	for _, profile := range profiles {
+		config, err := initializeAppConfig(flag.NewFlagSet(profile, flag.ExitOnError), nil, func(fs *flag.FlagSet) {
+			fs.Set("profile", profile)
+		})
+		if err != nil {
+			return fmt.Errorf("invalid/incomplete configuration for profile %v: %v", profile, err)
+		}
+		agent.configs[profile] = config
+	}
+	if len(profiles) == 1 {
+		agent.defaultProfile = profiles[0]
+	}
+
+	// Make sure all profiles have valid tokens before going into the background,
+	// which may require a (one-time) interactive login per profile
+	for _, profile := range profiles {
+		agent.mutex.Lock()
+		appConfig = agent.configs[profile]
+		_, err := acquireTokens()
+		agent.mutex.Unlock()
+		if err != nil {
+			return fmt.Errorf("could not obtain tokens for profile %v: %w", profile, err)
+		}
+	}
+
+	listener, err := listenOnUnixSocket(*socketPtr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Agent listening on %v for profiles: %v\n", *socketPtr, strings.Join(profiles, ", "))
+
+	go agent.serve(listener)
+	go agent.keepFresh(profiles)
+
+	// Waiting for SIGINT (kill -2) or SIGTERM (kill, systemd stop)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	signal.Stop(stop)
+
+	listener.Close()
+	os.Remove(*socketPtr)
+	return nil
+}
+
+func (a *Agent) keepFresh(profiles []string) {
+	for {
+		for _, profile := range profiles {
+			a.mutex.Lock()
+			appConfig = a.configs[profile]
+			cached, found := loadCachedTokens()
+			if !found || time.Until(cached.ExpiresAt) < effectiveRefreshMargin(cached.Tokens, a.refreshMargin) {
+				tokens, err := renewTokens()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "WARNING: could not refresh tokens for profile %v: %v\n", profile, err)
+				} else {
+					fmt.Fprintf(os.Stderr, "Refreshed tokens for profile %v (expires at %v)\n", profile, tokenExpiry(tokens))
+				}
+			}
+			a.mutex.Unlock()
+		}
+		time.Sleep(agentCheckInterval)
+	}
+}
+
+func (a *Agent) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return // listener closed
+		}
+		go a.handle(conn)
+	}
+}
+
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	encoder := json.NewEncoder(conn)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var request AgentRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			encoder.Encode(AgentResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+		encoder.Encode(a.tokens(request))
+	}
+}
+
+func (a *Agent) tokens(request AgentRequest) AgentResponse {
+	profile := request.Profile
+	if profile == "" {
+		profile = a.defaultProfile
+	}
+	response := AgentResponse{Profile: profile}
+	config, found := a.configs[profile]
+	if !found {
+		response.Error = fmt.Sprintf("unknown profile %q", profile)
+		return response
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	appConfig = config
+	cached, found := loadCachedTokens()
+	if !found || time.Until(cached.ExpiresAt) < tokenExpiryMargin {
+		tokens, err := renewTokens()
+		if err != nil {
+			response.Error = fmt.Sprintf("could not refresh tokens: %v", err)
+			return response
+		}
+		cached = CachedTokens{ExpiresAt: tokenExpiry(tokens), Tokens: tokens}
+	}
+	response.AccessToken = cached.Tokens.AccessToken
+	response.IDToken = cached.Tokens.IDToken
+	response.TokenType = cached.Tokens.TokenType
+	response.ExpiresAt = cached.ExpiresAt
+	return response
+}
+
+// Thin client for the agent; prints the access token (or the full response)
+func getCommand(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	profilePtr := fs.String("profile", parseStringEnvVar("", "O2TOKEN_PROFILE"), "Profile to get the access token for (optional if the agent only has one)")
+	jsonPtr := fs.Bool("json", false, "Print the full agent response as JSON")
+	socketPtr := fs.String("socket", defaultAgentSocketPath(), "Unix domain socket of the agent")
+	fs.Parse(args)
+
+	conn, err := net.DialTimeout("unix", *socketPtr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("could not connect to agent (is it running?): %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(AgentRequest{Profile: *profilePtr}); err != nil {
+		return fmt.Errorf("could not send request to agent: %v", err)
+	}
+	var response AgentResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return fmt.Errorf("could not read response from agent: %v", err)
+	}
+
+	if *jsonPtr {
+		output, _ := json.MarshalIndent(response, "", "  ")
+		fmt.Println(string(output))
+	}
+	if len(response.Error) > 0 {
+		return fmt.Errorf("agent error: %v", response.Error)
+	}
+	if !*jsonPtr {
+		fmt.Println(response.AccessToken)
+	}
+	return nil
+}
+
+func defaultAgentSocketPath() string {
+	path := os.Getenv("O2TOKEN_AGENT_SOCKET")
+	if len(path) > 0 {
+		return path
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "o2token", "agent.sock")
+}
+
+// Listen on a socket only accessible by the current user (replacing any stale socket file)
+func listenOnUnixSocket(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("could not create socket directory: %v", err)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another agent is already listening on %v", path)
+	}
+	os.Remove(path)
+
+	// The socket is created with the default umask, i.e. possibly accessible by others until
+	// its permissions are restricted; create it in a private directory and move it in place
+	privateDir, err := os.MkdirTemp(filepath.Dir(path), ".o2token-agent-")
+	if err != nil {
+		return nil, fmt.Errorf("could not create socket directory: %v", err)
+	}
+	defer os.RemoveAll(privateDir)
+	privatePath := filepath.Join(privateDir, filepath.Base(path))
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: privatePath, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %v: %v", path, err)
+	}
+	listener.SetUnlinkOnClose(false) // the socket file is moved; removed by the caller
+	if err := os.Chmod(privatePath, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not restrict access to %v: %v", path, err)
+	}
+	if err := os.Rename(privatePath, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not move socket to %v: %v", path, err)
+	}
+	return listener, nil
+}
@@ -1,64 +1,149 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"math/rand"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	h "o2token/helpers"
 )
 
 type AppConfig struct {
-	Address          string `json:"address"`
-	AuthEndpoint     string `json:"auth_endpoint"`
-	CallbackPath     string `json:"callback_path"`
-	ClientCredFlow   bool   `json:"client_cred_flow"`
-	ClientID         string `json:"client_id"`
-	ClientSecret     string `json:"client_secret"`
-	CodeChallenge    string `json:"code_challenge"`
-	CodeVerifier     string `json:"code_verifier"`
-	MetadataEndpoint string `json:"metadata_endpoint"`
-	NoBrowser        bool   `json:"no_browser"`
-	Pkce             bool   `json:"pkce"`
-	Port             uint   `json:"oauth2_port"`
-	RefreshToken     string `json:"refresh_token"`
-	Scope            string `json:"scope"`
-	State            string `json:"state"`
-	TokenEndpoint    string `json:"token_endpoint"`
-	UserInfoEndpoint string `json:"userinfo_endpoint"`
-	UserInfo         bool   `json:"userinfo"`
-	Verbose          bool   `json:"verbose"`
+	AcrValues            string     `json:"acr_values"`
+	Address              string     `json:"address"`
+	AllowWrongAudience   bool       `json:"allow_wrong_audience"`
+	Audiences            []string   `json:"audiences"`
+	AuthEndpoint         string     `json:"auth_endpoint"`
+	AuthParams           url.Values `json:"auth_params"`
+	AuthorizationDetails string     `json:"authorization_details"`
+	CallbackPath         string     `json:"callback_path"`
+	Claims               string     `json:"claims"`
+	ClientCredFlow       bool       `json:"client_cred_flow"`
+	ClientID             string     `json:"client_id"`
+	ClientSecret         string     `json:"client_secret"`
+	CodeChallenge        string     `json:"code_challenge"`
+	CodeVerifier         string     `json:"code_verifier"`
+	Dpop                 bool       `json:"dpop"`
+	ErrorJson            bool       `json:"error_json"`
+	IssParameterRequired bool       `json:"iss_parameter_required"`
+	Issuer               string     `json:"issuer"`
+	JarmDecryptionKey    string     `json:"jarm_decryption_key"`
+	JwksUri              string     `json:"jwks_uri"`
+	LoginHint            string     `json:"login_hint"`
+	MaxAge               string     `json:"max_age"`
+	MetadataEndpoint     string     `json:"metadata_endpoint"`
+	NoBrowser            bool       `json:"no_browser"`
+	Nonce                string     `json:"nonce"`
+	Par                  bool       `json:"par"`
+	ParEndpoint          string     `json:"par_endpoint"`
+	Pkce                 bool       `json:"pkce"`
+	Port                 uint       `json:"oauth2_port"`
+	Profile              string     `json:"profile"`
+	Prompt               string     `json:"prompt"`
+	ProtectedResource    string     `json:"protected_resource"`
+	RefreshToken         string     `json:"refresh_token"`
+	Resources            []string   `json:"resources"`
+	ResponseMode         string     `json:"response_mode"`
+	ResponseType         string     `json:"response_type"`
+	Scope                string     `json:"scope"`
+	State                string     `json:"state"`
+	TokenEndpoint        string     `json:"token_endpoint"`
+	TokenParams          url.Values `json:"token_params"`
+	UiLocales            string     `json:"ui_locales"`
+	UserInfoEndpoint     string     `json:"userinfo_endpoint"`
+	UserInfo             bool       `json:"userinfo"`
+	Verbose              bool       `json:"verbose"`
 }
 
-func initializeAppConfig() (AppConfig, error) {
+// The afterParse callback (optional) lets subcommands adjust settings that depend on their
+// own input, e.g. select a profile, before any defaults are applied or derived
+func initializeAppConfig(fs *flag.FlagSet, args []string, afterParse func(fs *flag.FlagSet)) (AppConfig, error) {
 	// General rules;
 	// - CLI arguments have precedence over ENV, i.e. those starting with "O2TOKEN_"
-	// - specified variables (CLI or ENV) will never be automatically derived
+	// - ENV has precedence over settings from a selected profile
+	// - specified variables (CLI, ENV or profile) will never be automatically derived
 
 	// Read from CLI or ENV (let ENV show as default if defined - but not for random/secret fields because they show up in --help)
-	addressPtr := flag.String("address", parseStringEnvVar("127.0.0.1", "O2TOKEN_ADDRESS"), "Address to bind to for local server")
-	authEndpointPtr := flag.String("auth-endpoint", parseStringEnvVar("", "O2TOKEN_AUTH_ENDPOINT"), "Authorization endpoint")
-	callbackPathPtr := flag.String("callback-path", parseStringEnvVar("/oauth2/callback", "O2TOKEN_CALLBACK_PATH"), "Oauth2 callback path")
-	clientCredFlowPtr := flag.Bool("client-cred-flow", parseBoolEnvVar(false, "O2TOKEN_CLIENT_CRED_FLOW"), "Use \"client credentials\" flow (not the \"code\" flow)")
-	clientIDPtr := flag.String("client-id", parseStringEnvVar("", "O2TOKEN_CLIENT_ID"), "Client (aka application) id ")
-	clientSecretPtr := flag.String("client-secret", "", "Client secret (if applicable)")
-	codeChallengePtr := flag.String("code-challenge", "", "PKCE Code Challenge (default <derived from verifier> when PKCE is enabled)")
-	codeVerifierPtr := flag.String("code-verifier", "", "PKCE Code Verifier (default <random> when PKCE is enabled)")
-	metadataEndpointPtr := flag.String("metadata-endpoint", parseStringEnvVar("", "O2TOKEN_METADATA_ENDPOINT"), "IDP base URL")
-	noBrowserPtr := flag.Bool("no-browser", parseBoolEnvVar(false, "O2TOKEN_NO_BROWSER"), "Prevent automatic launch of browser for login URL")
-	pkcePtr := flag.Bool("pkce", parseBoolEnvVar(true, "O2TOKEN_PKCE"), "Use Oauth2 with PKCE (S256)")
-	portPtr := flag.Uint("port", parseUintEnvVar(8080, "O2TOKEN_PORT"), "Local server port")
-	tokenEndpointPtr := flag.String("token-endpoint", parseStringEnvVar("", "O2TOKEN_TOKEN_ENDPOINT"), "Token endpoint")
-	refreshTokenPtr := flag.String("refresh-token", parseStringEnvVar("", "O2TOKEN_REFRESH_TOKEN"), "Refresh token to use when requesting new tokens")
-	statePtr := flag.String("state", parseStringEnvVar("", "O2TOKEN_STATE"), "Oauth2 state string (default <random>)")
-	scopePtr := flag.String("scope", parseStringEnvVar("openid,offline_access", "O2TOKEN_SCOPE"), "Access scope")
-	verbosePtr := flag.Bool("verbose", parseBoolEnvVar(false, "O2TOKEN_VERBOSE"), "Print progress and decoded/interpreted tokens")
-	userInfoPtr := flag.Bool("userinfo", parseBoolEnvVar(false, "O2TOKEN_USERINFO"), "Fetch user info after obtaining the access token")
-	userInfoEndpointPtr := flag.String("userinfo-endpoint", parseStringEnvVar("", "O2TOKEN_USERINFO_ENDPOINT"), "User info endpoint")
-	flag.Parse()
+	acrValuesPtr := fs.String("acr-values", parseStringEnvVar("", "O2TOKEN_ACR_VALUES"), "Requested authentication context class reference values (space separated)")
+	addressPtr := fs.String("address", parseStringEnvVar("127.0.0.1", "O2TOKEN_ADDRESS"), "Address to bind to for local server")
+	allowWrongAudiencePtr := fs.Bool("allow-wrong-audience", parseBoolEnvVar(false, "O2TOKEN_ALLOW_WRONG_AUDIENCE"), "Only warn (instead of failing) if the access token isn't issued for the requested resources/audiences")
+	var audiences stringListFlag
+	fs.Var(&audiences, "audience", "Requested audience of the access token (repeatable)")
+	authEndpointPtr := fs.String("auth-endpoint", parseStringEnvVar("", "O2TOKEN_AUTH_ENDPOINT"), "Authorization endpoint")
+	var authParams stringListFlag
+	fs.Var(&authParams, "auth-param", "Additional authorization request parameter as key=value (repeatable, replaces a standard parameter with the same key)")
+	authorizationDetailsPtr := fs.String("authorization-details", parseStringEnvVar("", "O2TOKEN_AUTHORIZATION_DETAILS"), "Rich authorization request details as JSON array, inline or @<file> (RFC 9396)")
+	callbackPathPtr := fs.String("callback-path", parseStringEnvVar("/oauth2/callback", "O2TOKEN_CALLBACK_PATH"), "Oauth2 callback path")
+	claimsPtr := fs.String("claims", parseStringEnvVar("", "O2TOKEN_CLAIMS"), "Requested claims as JSON object, inline or @<file> (OIDC \"claims\" request parameter)")
+	clientCredFlowPtr := fs.Bool("client-cred-flow", parseBoolEnvVar(false, "O2TOKEN_CLIENT_CRED_FLOW"), "Use \"client credentials\" flow (not the \"code\" flow)")
+	clientIDPtr := fs.String("client-id", parseStringEnvVar("", "O2TOKEN_CLIENT_ID"), "Client (aka application) id ")
+	clientSecretPtr := fs.String("client-secret", "", "Client secret (if applicable)")
+	codeChallengePtr := fs.String("code-challenge", "", "PKCE Code Challenge (default <derived from verifier> when PKCE is enabled)")
+	codeVerifierPtr := fs.String("code-verifier", "", "PKCE Code Verifier (default <random> when PKCE is enabled)")
+	dpopPtr := fs.Bool("dpop", parseBoolEnvVar(false, "O2TOKEN_DPOP"), "Request DPoP-bound tokens and use DPoP proofs with them (RFC 9449)")
+	errorJsonPtr := fs.Bool("error-json", parseBoolEnvVar(false, "O2TOKEN_ERROR_JSON"), "Report errors as JSON on stderr (for scripts)")
+	issParameterRequiredPtr := fs.Bool("iss-parameter-required", parseBoolEnvVar(false, "O2TOKEN_ISS_PARAMETER_REQUIRED"), "Reject authorization responses without an \"iss\" parameter (default <from metadata>, RFC 9207)")
+	issuerPtr := fs.String("issuer", parseStringEnvVar("", "O2TOKEN_ISSUER"), "Issuer identifier of the IDP")
+	jarmDecryptionKeyPtr := fs.String("jarm-decryption-key", parseStringEnvVar("", "O2TOKEN_JARM_DECRYPTION_KEY"), "Private key file (PEM or JWK) for encrypted JWT secured authorization responses")
+	jwksUriPtr := fs.String("jwks-uri", parseStringEnvVar("", "O2TOKEN_JWKS_URI"), "JWKS endpoint of the IDP (signing keys)")
+	loginHintPtr := fs.String("login-hint", parseStringEnvVar("", "O2TOKEN_LOGIN_HINT"), "Hint about the user's login identifier, e.g. an email address")
+	maxAgePtr := fs.String("max-age", parseStringEnvVar("", "O2TOKEN_MAX_AGE"), "Maximum authentication age in seconds (forces re-authentication if older)")
+	metadataEndpointPtr := fs.String("metadata-endpoint", parseStringEnvVar("", "O2TOKEN_METADATA_ENDPOINT"), "IDP base URL")
+	noBrowserPtr := fs.Bool("no-browser", parseBoolEnvVar(false, "O2TOKEN_NO_BROWSER"), "Prevent automatic launch of browser for login URL")
+	noncePtr := fs.String("nonce", parseStringEnvVar("", "O2TOKEN_NONCE"), "OIDC nonce (default <random> when an ID token is requested from the authorization endpoint)")
+	parPtr := fs.Bool("par", parseBoolEnvVar(false, "O2TOKEN_PAR"), "Push the authorization request parameters to the IDP first (default <from metadata>, RFC 9126)")
+	parEndpointPtr := fs.String("par-endpoint", parseStringEnvVar("", "O2TOKEN_PAR_ENDPOINT"), "Pushed authorization request endpoint")
+	pkcePtr := fs.Bool("pkce", parseBoolEnvVar(true, "O2TOKEN_PKCE"), "Use Oauth2 with PKCE (S256)")
+	portPtr := fs.Uint("port", parseUintEnvVar(8080, "O2TOKEN_PORT"), "Local server port")
+	profilePtr := fs.String("profile", parseStringEnvVar("", "O2TOKEN_PROFILE"), "Named profile (from the profiles file) providing defaults for unspecified settings")
+	promptPtr := fs.String("prompt", parseStringEnvVar("", "O2TOKEN_PROMPT"), "Requested prompt behavior, e.g. none, login, consent or select_account (space separated)")
+	protectedResourcePtr := fs.String("protected-resource", parseStringEnvVar("", "O2TOKEN_PROTECTED_RESOURCE"), "Resource URL to discover the IDP (and scope) from when not configured (RFC 9728)")
+	tokenEndpointPtr := fs.String("token-endpoint", parseStringEnvVar("", "O2TOKEN_TOKEN_ENDPOINT"), "Token endpoint")
+	var tokenParams stringListFlag
+	fs.Var(&tokenParams, "token-param", "Additional token request parameter as key=value (repeatable, replaces a standard parameter with the same key)")
+	refreshTokenPtr := fs.String("refresh-token", parseStringEnvVar("", "O2TOKEN_REFRESH_TOKEN"), "Refresh token to use when requesting new tokens")
+	var resources stringListFlag
+	fs.Var(&resources, "resource", "Resource indicator, i.e. target API, for the access token (repeatable, RFC 8707)")
+	responseModePtr := fs.String("response-mode", parseStringEnvVar("", "O2TOKEN_RESPONSE_MODE"), "How the IDP returns the authorization response; query, form_post, fragment or (JARM) jwt, query.jwt, form_post.jwt or fragment.jwt (default <not sent>)")
+	responseTypePtr := fs.String("response-type", parseStringEnvVar("code", "O2TOKEN_RESPONSE_TYPE"), "Response type, e.g. code, id_token, \"id_token token\" or \"code id_token\" (space or comma separated)")
+	statePtr := fs.String("state", parseStringEnvVar("", "O2TOKEN_STATE"), "Oauth2 state string (default <random>)")
+	scopePtr := fs.String("scope", parseStringEnvVar("openid,offline_access", "O2TOKEN_SCOPE"), "Access scope")
+	uiLocalesPtr := fs.String("ui-locales", parseStringEnvVar("", "O2TOKEN_UI_LOCALES"), "Preferred languages for the login UI, e.g. \"sv en\" (space separated)")
+	verbosePtr := fs.Bool("verbose", parseBoolEnvVar(false, "O2TOKEN_VERBOSE"), "Print progress and decoded/interpreted tokens")
+	userInfoPtr := fs.Bool("userinfo", parseBoolEnvVar(false, "O2TOKEN_USERINFO"), "Fetch user info after obtaining the access token")
+	userInfoEndpointPtr := fs.String("userinfo-endpoint", parseStringEnvVar("", "O2TOKEN_USERINFO_ENDPOINT"), "User info endpoint")
+	fs.Parse(args)
+	if afterParse != nil {
+		afterParse(fs)
+	}
+
+	// Fill in the blanks from the selected profile (if any)
+	if len(*profilePtr) > 0 {
+		if err := applyProfile(fs, *profilePtr); err != nil {
+			return AppConfig{}, err
+		}
+	}
+
+	// Lists from ENV are only used if not specified at all (i.e. not combined with CLI values)
+	if len(audiences) == 0 {
+		audiences = parseListEnvVar("O2TOKEN_AUDIENCE")
+	}
+	if len(resources) == 0 {
+		resources = parseListEnvVar("O2TOKEN_RESOURCE")
+	}
+	if len(authParams) == 0 {
+		authParams = strings.Fields(os.Getenv("O2TOKEN_AUTH_PARAM"))
+	}
+	if len(tokenParams) == 0 {
+		tokenParams = strings.Fields(os.Getenv("O2TOKEN_TOKEN_PARAM"))
+	}
 
 	// Handle special defaults (random/secrets)
 	if *clientSecretPtr == "" {
@@ -69,6 +154,11 @@ func initializeAppConfig() (AppConfig, error) {
 		randStr := genRandStr()
 		statePtr = &randStr
 	}
+	responseTypeStr := strings.Join(strings.Fields(strings.ReplaceAll(*responseTypePtr, ",", " ")), " ")
+	if *noncePtr == "" && strings.Contains(responseTypeStr, "id_token") {
+		randStr := genRandStr()
+		noncePtr = &randStr
+	}
 	if *pkcePtr {
 		if *codeVerifierPtr == "" {
 			verifierStr := genPkceCodeVerifier()
@@ -80,6 +170,33 @@ func initializeAppConfig() (AppConfig, error) {
 		}
 	}
 
+	// Discover the IDP via the protected resource's metadata (unless configured)
+	if len(*protectedResourcePtr) > 0 && len(*metadataEndpointPtr) == 0 && len(*authEndpointPtr) == 0 {
+		if *verbosePtr {
+			fmt.Println("Discovering IDP via protected resource metadata")
+		}
+		resourceMeta, err := discoverProtectedResource(*protectedResourcePtr, *verbosePtr)
+		if err != nil {
+			return AppConfig{}, err
+		}
+		metadataEndpoint, err := discoverMetadataEndpoint(resourceMeta.AuthorizationServers[0])
+		if err != nil {
+			return AppConfig{}, err
+		}
+		metadataEndpointPtr = &metadataEndpoint
+		if len(resources) == 0 && len(resourceMeta.Resource) > 0 {
+			resources = append(resources, resourceMeta.Resource)
+		}
+		scopeSpecified := isFlagSpecified(fs, "scope") || len(os.Getenv("O2TOKEN_SCOPE")) > 0
+		if !scopeSpecified && len(resourceMeta.ScopesSupported) > 0 {
+			scopeStr := strings.Join(resourceMeta.ScopesSupported, " ")
+			if !*clientCredFlowPtr {
+				scopeStr = *scopePtr + " " + scopeStr // keep "openid" etc. for the code flow
+			}
+			scopePtr = &scopeStr
+		}
+	}
+
 	// Derive unspecified fields based on IDP's metadata
 	if len(*metadataEndpointPtr) > 0 {
 		if *verbosePtr {
@@ -96,31 +213,89 @@ func initializeAppConfig() (AppConfig, error) {
 		if len(*userInfoEndpointPtr) == 0 {
 			userInfoEndpointPtr = &idpMeta.UserInfoEndpoint
 		}
+		if len(*issuerPtr) == 0 {
+			issuerPtr = &idpMeta.Issuer
+		}
+		if len(*jwksUriPtr) == 0 {
+			jwksUriPtr = &idpMeta.JwksUri
+		}
+		if len(*parEndpointPtr) == 0 {
+			parEndpointPtr = &idpMeta.ParEndpoint
+		}
+		if !isFlagSpecified(fs, "par") && len(os.Getenv("O2TOKEN_PAR")) == 0 {
+			parPtr = &idpMeta.RequirePushedAuthorizationRequests
+		}
+		if !isFlagSpecified(fs, "iss-parameter-required") && len(os.Getenv("O2TOKEN_ISS_PARAMETER_REQUIRED")) == 0 {
+			issParameterRequiredPtr = &idpMeta.AuthorizationResponseIssParameterSupported
+		}
+	}
+
+	// Normalize JSON parameters (compact, as sent in requests) and additional parameters
+	authorizationDetails, err := readCompactJson(*authorizationDetailsPtr, "[")
+	if err != nil {
+		return AppConfig{}, fmt.Errorf("invalid authorization details (expected JSON array): %v", err)
+	}
+	claims, err := readCompactJson(*claimsPtr, "{")
+	if err != nil {
+		return AppConfig{}, fmt.Errorf("invalid claims request (expected JSON object): %v", err)
+	}
+	authParamValues, err := parseParamList(authParams)
+	if err != nil {
+		return AppConfig{}, fmt.Errorf("invalid authorization request parameter: %v", err)
+	}
+	tokenParamValues, err := parseParamList(tokenParams)
+	if err != nil {
+		return AppConfig{}, fmt.Errorf("invalid token request parameter: %v", err)
 	}
 
 	//Fix scope-string; input supports either " " or "," as separator but when used, it must be " "
 	scopeStr := strings.ReplaceAll(*scopePtr, ",", " ")
 
 	config := AppConfig{
-		Address:          *addressPtr,
-		AuthEndpoint:     *authEndpointPtr,
-		CallbackPath:     *callbackPathPtr,
-		ClientCredFlow:   *clientCredFlowPtr,
-		ClientID:         *clientIDPtr,
-		CodeChallenge:    *codeChallengePtr,
-		CodeVerifier:     *codeVerifierPtr,
-		ClientSecret:     *clientSecretPtr,
-		MetadataEndpoint: *metadataEndpointPtr,
-		NoBrowser:        *noBrowserPtr,
-		Pkce:             *pkcePtr,
-		Port:             *portPtr,
-		RefreshToken:     *refreshTokenPtr,
-		State:            *statePtr,
-		Scope:            scopeStr,
-		TokenEndpoint:    *tokenEndpointPtr,
-		Verbose:          *verbosePtr,
-		UserInfo:         *userInfoPtr,
-		UserInfoEndpoint: *userInfoEndpointPtr,
+		AcrValues:            *acrValuesPtr,
+		Address:              *addressPtr,
+		AllowWrongAudience:   *allowWrongAudiencePtr,
+		Audiences:            strings.Fields(strings.Join(audiences, " ")),
+		AuthEndpoint:         *authEndpointPtr,
+		AuthParams:           authParamValues,
+		AuthorizationDetails: authorizationDetails,
+		CallbackPath:         *callbackPathPtr,
+		Claims:               claims,
+		ClientCredFlow:       *clientCredFlowPtr,
+		ClientID:             *clientIDPtr,
+		CodeChallenge:        *codeChallengePtr,
+		CodeVerifier:         *codeVerifierPtr,
+		ClientSecret:         *clientSecretPtr,
+		Dpop:                 *dpopPtr,
+		ErrorJson:            *errorJsonPtr,
+		IssParameterRequired: *issParameterRequiredPtr,
+		Issuer:               *issuerPtr,
+		JarmDecryptionKey:    *jarmDecryptionKeyPtr,
+		JwksUri:              *jwksUriPtr,
+		LoginHint:            *loginHintPtr,
+		MaxAge:               *maxAgePtr,
+		MetadataEndpoint:     *metadataEndpointPtr,
+		NoBrowser:            *noBrowserPtr,
+		Nonce:                *noncePtr,
+		Par:                  *parPtr,
+		ParEndpoint:          *parEndpointPtr,
+		Pkce:                 *pkcePtr,
+		Port:                 *portPtr,
+		Profile:              *profilePtr,
+		Prompt:               *promptPtr,
+		ProtectedResource:    *protectedResourcePtr,
+		RefreshToken:         *refreshTokenPtr,
+		Resources:            strings.Fields(strings.Join(resources, " ")),
+		ResponseMode:         *responseModePtr,
+		ResponseType:         responseTypeStr,
+		State:                *statePtr,
+		Scope:                scopeStr,
+		TokenEndpoint:        *tokenEndpointPtr,
+		TokenParams:          tokenParamValues,
+		UiLocales:            *uiLocalesPtr,
+		Verbose:              *verbosePtr,
+		UserInfo:             *userInfoPtr,
+		UserInfoEndpoint:     *userInfoEndpointPtr,
 	}
 
 	// Some level of input validation...
@@ -135,8 +310,18 @@ func initializeAppConfig() (AppConfig, error) {
 		retErr = fmt.Errorf("client ID not configured")
 	} else if config.UserInfo && config.UserInfoEndpoint == "" {
 		retErr = fmt.Errorf("missing UserInfoEndpoint configuration")
+	} else if config.Par && config.ParEndpoint == "" {
+		retErr = fmt.Errorf("pushed authorization requests enabled but no PAR endpoint configured")
 	} else if config.State == "" {
 		retErr = fmt.Errorf("empty state string configured")
+	} else if _, err := strconv.ParseUint(config.MaxAge, 10, 64); config.MaxAge != "" && err != nil {
+		retErr = fmt.Errorf("invalid max age configured")
+	} else if !isSupportedResponseMode(config.ResponseMode) {
+		retErr = fmt.Errorf("unsupported response mode configured")
+	} else if !isSupportedResponseType(config.ResponseType) {
+		retErr = fmt.Errorf("unsupported response type configured")
+	} else if strings.HasPrefix(config.ResponseMode, "query") && config.ResponseType != "code" && !isJarmResponseMode(config.ResponseMode) {
+		retErr = fmt.Errorf("tokens must not be returned in the query (use another response mode)")
 	}
 
 	if config.Verbose || retErr != nil {
@@ -156,6 +341,60 @@ func initializeAppConfig() (AppConfig, error) {
 	return config, retErr
 }
 
+// Repeatable CLI flag (e.g. "--header a --header b")
+type stringListFlag []string
+
+func (l *stringListFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringListFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func isSupportedResponseMode(mode string) bool {
+	switch mode {
+	case "", "query", "form_post", "fragment", "jwt", "query.jwt", "form_post.jwt", "fragment.jwt":
+		return true
+	}
+	return false
+}
+
+// Compact JSON from a value or file (empty if not specified) that must start with the given delimiter
+func readCompactJson(value string, delimiter string) (string, error) {
+	raw, err := h.ReadValueOrFile(value)
+	if err != nil || raw == nil {
+		return "", err
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(compact.String(), delimiter) {
+		return "", fmt.Errorf("unexpected JSON type")
+	}
+	return compact.String(), nil
+}
+
+// Parameters given as key=value (a key may be repeated)
+func parseParamList(list []string) (url.Values, error) {
+	params := url.Values{}
+	for _, param := range list {
+		key, value, found := strings.Cut(param, "=")
+		if !found || len(key) == 0 {
+			return nil, fmt.Errorf("%q (expected key=value)", param)
+		}
+		params.Add(key, value)
+	}
+	return params, nil
+}
+
+// A list of values separated by space or comma (empty if not defined)
+func parseListEnvVar(envVar string) []string {
+	return strings.Fields(strings.ReplaceAll(os.Getenv(envVar), ",", " "))
+}
+
 func parseBoolEnvVar(defaultValue bool, envVar string) bool {
 	retVal := defaultValue
 	var err error
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	h "o2token/helpers"
+)
+
+// Perform an HTTP request, authorized with an access token obtained from the cache, a refresh
+// token or a new flow (in that order). The response is printed with JSON/JWT bodies decoded.
+// If the resource demands step-up authentication, a new code flow is run and the request retried.
+
+const callUsageMsg = `Usage: o2token call [optional flags] <url>`
+
+func callCommand(args []string) error {
+	fs := flag.NewFlagSet("call", flag.ExitOnError)
+	methodPtr := fs.String("method", "", "HTTP method (default GET, or POST if a body is provided)")
+	dataPtr := fs.String("data", "", "Request body; a literal string, @<file> or @- for stdin")
+	var headers stringListFlag
+	fs.Var(&headers, "header", "Additional request header as \"Name: value\" (repeatable)")
+
+	var err error
+	appConfig, err = initializeAppConfig(fs, args, func(fs *flag.FlagSet) {
+		// The IDP can be discovered from the resource itself if not configured
+		if fs.NArg() == 1 {
+			setFlagUnlessSpecified(fs, "protected-resource", fs.Arg(0))
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("invalid/incomplete application configuration: %v", err)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one URL\n%v", callUsageMsg)
+	}
+	target := fs.Arg(0)
+
+	body, err := h.ReadValueOrFile(*dataPtr)
+	if err != nil {
+		return fmt.Errorf("could not read request body: %v", err)
+	}
+	method := strings.ToUpper(*methodPtr)
+	if method == "" {
+		method = http.MethodGet
+		if body != nil {
+			method = http.MethodPost
+		}
+	}
+
+	tokens, err := acquireTokens()
+	if err != nil {
+		return fmt.Errorf("could not obtain access token: %w", err)
+	}
+
+	res, err := sendCallRequest(method, target, headers, body, tokens.AccessToken)
+	if err != nil {
+		return err
+	}
+	if challenge, found := stepUpChallenge(res); found && !appConfig.ClientCredFlow {
+		res.Body.Close()
+		tokens, err = stepUpTokens(challenge)
+		if err != nil {
+			return fmt.Errorf("step-up authentication failed: %w", err)
+		}
+		res, err = sendCallRequest(method, target, headers, body, tokens.AccessToken)
+		if err != nil {
+			return err
+		}
+	}
+	defer res.Body.Close()
+
+	if err := printCallResponse(res); err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		explainUnauthorized(res)
+	}
+	if res.StatusCode >= 400 {
+		exitCode = 1
+	}
+	return nil
+}
+
+func sendCallRequest(method string, target string, headers []string, body []byte, accessToken string) (*http.Response, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, target, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %v", err)
+	}
+	for _, header := range headers {
+		name, value, found := strings.Cut(header, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid header %q (expected \"Name: value\")", header)
+		}
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if body != nil && req.Header.Get("content-type") == "" && json.Valid(body) {
+		req.Header.Set("content-type", "application/json")
+	}
+	if err := setAuthorizationHeader(req, accessToken); err != nil {
+		return nil, err
+	}
+
+	httpClient := http.Client{}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send request: %v", err)
+	}
+	if appConfig.Verbose {
+		fmt.Printf("Sent %v request to %v\n", method, target)
+	}
+	return res, nil
+}
+
+// Status line, headers (sorted) and the body; pretty-printed if it is JSON or a JWT
+func printCallResponse(res *http.Response) error {
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response body: %v", err)
+	}
+
+	fmt.Printf("%v %v\n", res.Proto, res.Status)
+	names := make([]string, 0, len(res.Header))
+	for name := range res.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range res.Header[name] {
+			fmt.Printf("%v: %v\n", name, value)
+		}
+	}
+	fmt.Println()
+
+	bodyStr := strings.TrimSpace(string(bodyBytes))
+	contentType := res.Header.Get("content-type")
+	switch {
+	case len(bodyStr) == 0:
+	case json.Valid(bodyBytes):
+		fmt.Println(h.PrettyJson(bodyStr))
+	case strings.HasPrefix(contentType, "application/jwt") || isJwt(bodyStr):
+		fmt.Printf("%v\n\nDecoded JWT:\n------------\n%v\n", bodyStr, interpretJwt(bodyStr))
+	default:
+		fmt.Println(string(bodyBytes))
+	}
+	return nil
+}
+
+func explainUnauthorized(res *http.Response) {
+	headers := res.Header.Values("WWW-Authenticate")
+	if len(headers) == 0 {
+		fmt.Fprintf(os.Stderr, "\nThe access token was rejected (no WWW-Authenticate header to tell why)\n")
+		return
+	}
+	challenges, err := parseAuthChallenges(headers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nCould not parse WWW-Authenticate header: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\nThe access token was rejected:\n")
+	for _, challenge := range challenges {
+		fmt.Fprintf(os.Stderr, "\n%v\n", explainChallenge(challenge))
+	}
+}
+
+// Three dot-separated base64url sections where the first one decodes to a JSON object
+func isJwt(value string) bool {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || strings.ContainsAny(value, " \n") {
+		return false
+	}
+	header, err := h.Base64UrlDecode(parts[0])
+	return err == nil && json.Valid(header)
+}
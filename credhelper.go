@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Common parts of the credential helpers (git, docker);
+// - the server is mapped to a profile via its host name
+// - the response is written to stdout, i.e. all other output must be diverted
+
+// Username accompanying the access token (when used as password)
+const credentialUsername = "oauth2"
+
+// Initialize the application configuration using the profile associated with the host, unless
+// a profile has been specified explicitly. Nothing found (false) without an error means that
+// the request should be left for other helpers; a configuration via CLI or ENV alone is not
+// enough because the token would be handed out to any host asking for credentials.
+func initializeAppConfigForHost(fs *flag.FlagSet, args []string, host string) (bool, error) {
+	profileSelected := false
+	var err error
+	appConfig, err = initializeAppConfig(fs, args, func(fs *flag.FlagSet) {
+		if profile, found := profileForHost(host); found {
+			setFlagUnlessSpecified(fs, "profile", profile)
+		}
+		profileSelected = len(fs.Lookup("profile").Value.String()) > 0
+	})
+	if !profileSelected {
+		if fs.Lookup("verbose").Value.String() == "true" {
+			fmt.Fprintf(os.Stderr, "No profile for host %v\n", host)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("invalid/incomplete application configuration: %v", err)
+	}
+	return true, nil
+}
+
+// Extract the host from a server reference which may, or may not, be a full URL
+func serverHost(server string) string {
+	server = strings.TrimSpace(server)
+	if strings.Contains(server, "://") {
+		if u, err := url.Parse(server); err == nil {
+			return u.Host
+		}
+	}
+	return strings.Split(server, "/")[0]
+}
+
+// Send all regular output to stderr and return the original stdout (for the actual response)
+func divertStdout() *os.File {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	return stdout
+}
+
+func restoreStdout(stdout *os.File) {
+	os.Stdout = stdout
+}
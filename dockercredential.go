@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Docker credential helper, enabled by making the binary available as "docker-credential-o2token"
+// (e.g. via a symlink) and configuring it in ~/.docker/config.json:
+//
+//	{ "credHelpers": { "registry.example.com": "o2token" } }
+//
+// 👉 https://github.com/docker/docker-credential-helpers#development
+//
+// Docker invokes the helper without any flags, i.e. the configuration must be provided via
+// profiles (mapped by host) and/or environment variables.
+// By default the access token is returned as password for basic authentication against the
+// registry's token service. Registries that accept an OAuth2 refresh token (docker's "identity
+// token") instead are supported by enabling the "identity-token" setting.
+
+const dockerCredentialHelperName = "docker-credential-o2token"
+
+// Docker's magic username for identity tokens
+const dockerIdentityTokenUsername = "<token>"
+
+type DockerCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+func dockerCredentialCommand(args []string) error {
+	// Docker reads the response from stdout so all other (verbose) output must go elsewhere
+	stdout := divertStdout()
+	defer restoreStdout(stdout)
+
+	fs := flag.NewFlagSet("docker-credential", flag.ExitOnError)
+	identityTokenPtr := fs.Bool("identity-token", parseBoolEnvVar(false, "O2TOKEN_IDENTITY_TOKEN"), "Return the refresh token as docker identity token instead of the access token as password")
+
+	// The action is the last argument (after any flags)
+	if len(args) == 0 {
+		return fmt.Errorf("missing action (expected get, store, erase or list)")
+	}
+	action := args[len(args)-1]
+	args = args[:len(args)-1]
+
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("could not read request: %v", err)
+	}
+
+	switch action {
+	case "get":
+		serverURL := strings.TrimSpace(string(input))
+		found, err := initializeAppConfigForHost(fs, args, serverHost(serverURL))
+		if !found {
+			if err == nil {
+				// The message is part of the protocol and tells docker to carry on without credentials
+				fmt.Fprintln(stdout, "credentials not found in native keychain")
+				exitCode = 1
+			}
+			return err
+		}
+		tokens, err := acquireTokens()
+		if err != nil {
+			return fmt.Errorf("could not obtain tokens: %w", err)
+		}
+		credentials := DockerCredentials{
+			ServerURL: serverURL,
+			Username:  credentialUsername,
+			Secret:    tokens.AccessToken,
+		}
+		if *identityTokenPtr {
+			if len(tokens.RefreshToken) == 0 {
+				return fmt.Errorf("no refresh token available to use as identity token (is offline_access in scope?)")
+			}
+			credentials.Username = dockerIdentityTokenUsername
+			credentials.Secret = tokens.RefreshToken
+		}
+		return writeDockerResponse(stdout, credentials)
+	case "store":
+		// Nothing to do; credentials are never stored on behalf of docker
+		return nil
+	case "erase":
+		serverURL := strings.TrimSpace(string(input))
+		found, err := initializeAppConfigForHost(fs, args, serverHost(serverURL))
+		if !found {
+			return err
+		}
+		return expireCachedTokens()
+	case "list":
+		return writeDockerResponse(stdout, dockerProfileServers())
+	default:
+		return fmt.Errorf("unsupported action %q (expected get, store, erase or list)", action)
+	}
+}
+
+func writeDockerResponse(w io.Writer, response interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false) // keep "<token>" readable
+	return encoder.Encode(response)
+}
+
+// All hosts of all profiles (server URL -> username)
+func dockerProfileServers() map[string]string {
+	servers := map[string]string{}
+	profiles, err := loadProfiles()
+	if err != nil {
+		return servers
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		username := credentialUsername
+		if identityToken, err := strconv.ParseBool(profiles[name].Settings["identity-token"]); err == nil && identityToken {
+			username = dockerIdentityTokenUsername
+		}
+		for _, host := range profiles[name].Hosts {
+			if _, found := servers[host]; !found {
+				servers[host] = username
+			}
+		}
+	}
+	return servers
+}
+
+// Allow the binary to be invoked as a docker credential helper (via symlink or copy)
+func isDockerCredentialHelper() bool {
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	return name == dockerCredentialHelperName
+}
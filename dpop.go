@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Demonstrating Proof of Possession (DPoP), i.e. sender-constrained tokens
+// 👉 https://datatracker.ietf.org/doc/html/rfc9449
+//
+// The (ES256) key is stored next to the cached tokens since refresh tokens issued to public
+// clients are bound to the key they were requested with.
+
+// Keys loaded/created so far (key file path -> key)
+var dpopKeys = map[string]*ecdsa.PrivateKey{}
+var dpopMutex sync.Mutex
+
+// Nonces provided by servers via the "DPoP-Nonce" header (origin -> nonce)
+var dpopNonces = map[string]string{}
+
+func dpopKeyPath() (string, error) {
+	tokenPath, err := tokenCachePath()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(tokenPath, ".json") + ".dpop.pem", nil
+}
+
+func loadOrCreateDpopKey() (*ecdsa.PrivateKey, error) {
+	path, err := dpopKeyPath()
+	if err != nil {
+		return nil, err
+	}
+
+	dpopMutex.Lock()
+	defer dpopMutex.Unlock()
+	if key, found := dpopKeys[path]; found {
+		return key, nil
+	}
+	if pemBytes, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(pemBytes)
+		if block == nil {
+			return nil, fmt.Errorf("invalid DPoP key file %v", path)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse DPoP key %v: %v", path, err)
+		}
+		dpopKeys[path] = key
+		return key, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate DPoP key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode DPoP key: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("could not create DPoP key directory: %v", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("could not store DPoP key: %v", err)
+	}
+	if appConfig.Verbose {
+		fmt.Printf("Generated new DPoP key %v\n", path)
+	}
+	dpopKeys[path] = key
+	return key, nil
+}
+
+// Create a DPoP proof JWT for a request (the access token is only included for resource requests)
+func dpopProof(method string, target string, accessToken string) (string, error) {
+	key, err := loadOrCreateDpopKey()
+	if err != nil {
+		return "", err
+	}
+	htu, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid DPoP target URL: %v", err)
+	}
+	htu.RawQuery = ""
+	htu.Fragment = ""
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	header := map[string]interface{}{
+		"typ": "dpop+jwt",
+		"alg": "ES256",
+		"jwk": map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+		},
+	}
+	claims := map[string]interface{}{
+		"jti": genRandStr(),
+		"htm": method,
+		"htu": htu.String(),
+		"iat": time.Now().Unix(),
+	}
+	if len(accessToken) > 0 {
+		hash := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(hash[:])
+	}
+	dpopMutex.Lock()
+	if nonce, found := dpopNonces[origin(htu)]; found {
+		claims["nonce"] = nonce
+	}
+	dpopMutex.Unlock()
+
+	headerJson, _ := json.Marshal(header)
+	claimsJson, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(claimsJson)
+	hash := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("could not sign DPoP proof: %v", err)
+	}
+	signature := append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Remember a server provided nonce; returns true if it was new (i.e. a retry makes sense)
+func updateDpopNonce(target *url.URL, res *http.Response) bool {
+	nonce := res.Header.Get("DPoP-Nonce")
+	if len(nonce) == 0 {
+		return false
+	}
+	dpopMutex.Lock()
+	defer dpopMutex.Unlock()
+	if dpopNonces[origin(target)] == nonce {
+		return false
+	}
+	dpopNonces[origin(target)] = nonce
+	return true
+}
+
+// Add a DPoP proof to a request (if enabled)
+func setDpopHeader(req *http.Request, accessToken string) error {
+	if !appConfig.Dpop {
+		return nil
+	}
+	proof, err := dpopProof(req.Method, req.URL.String(), accessToken)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("DPoP", proof)
+	return nil
+}
+
+// Authorize a resource request with the access token (as DPoP-bound token if enabled)
+func setAuthorizationHeader(req *http.Request, accessToken string) error {
+	if !appConfig.Dpop {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		return nil
+	}
+	req.Header.Set("Authorization", "DPoP "+accessToken)
+	return setDpopHeader(req, accessToken)
+}
+
+func origin(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Git credential helper, configured with e.g.
+//
+//	git config --global credential.https://git.example.com.helper "/path/to/o2token git-credential"
+//
+// Git appends the action (get/store/erase) and passes the request attributes via stdin.
+// 👉 https://git-scm.com/docs/git-credential#IOFMT
+//
+// The host is mapped to a profile (unless one is explicitly specified) and the access token
+// is returned as password. Tokens are never stored by git; they are managed in our own cache.
+
+func gitCredentialCommand(args []string) error {
+	// Git reads the response from stdout so all other (verbose) output must go elsewhere
+	stdout := divertStdout()
+	defer restoreStdout(stdout)
+
+	attributes, err := readCredentialAttributes(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("git-credential", flag.ExitOnError)
+	found, err := initializeAppConfigForHost(fs, args, attributes["host"])
+	if !found {
+		return err // not our business; let git try other helpers (or prompt the user)
+	}
+
+	switch fs.Arg(0) {
+	case "get":
+		tokens, err := acquireTokens()
+		if err != nil {
+			return fmt.Errorf("could not obtain access token: %w", err)
+		}
+		fmt.Fprintf(stdout, "username=%v\n", credentialUsername)
+		fmt.Fprintf(stdout, "password=%v\n", tokens.AccessToken)
+		fmt.Fprintf(stdout, "password_expiry_utc=%v\n", tokenExpiry(tokens).Unix())
+	case "store":
+		// Nothing to do; the tokens were cached when they were obtained
+	case "erase":
+		// The access token was rejected; make sure it isn't handed out again
+		return expireCachedTokens()
+	default:
+		// Git may introduce new actions; helpers are expected to ignore the ones they don't know
+	}
+	return nil
+}
+
+// Parse "key=value" lines until an empty line or EOF
+func readCredentialAttributes(r io.Reader) (map[string]string, error) {
+	attributes := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) == 0 {
+			break
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid credential attribute line: %q", line)
+		}
+		attributes[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read credential attributes: %v", err)
+	}
+	return attributes, nil
+}
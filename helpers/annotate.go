@@ -0,0 +1,263 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Explains a JSON value (empty if there is nothing to explain); the path tells where the value
+// is found, e.g. "exp", "cnf.jkt", "amr[0]" or `events["http://schemas.openid.net/event/backchannel-logout"]`
+// (see MemberPath)
+type Annotator func(path string, value interface{}) string
+
+// Pretty printed JSON (in its original order) with jsonc-style comments from the annotators
+// (fail silent for all kinds of annotation errors)
+func AnnotateJson(jsonStr string, annotators ...Annotator) string {
+	if !json.Valid([]byte(jsonStr)) {
+		return fmt.Sprintf("Invalid JSON: %v", jsonStr)
+	}
+	var out strings.Builder
+	writeAnnotated(&out, json.RawMessage(strings.TrimSpace(jsonStr)), "", "", "", true, annotators)
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+func writeAnnotated(out *strings.Builder, raw json.RawMessage, path string, indent string, prefix string, last bool, annotators []Annotator) {
+	comma := ","
+	if last {
+		comma = ""
+	}
+	comment := ""
+	var value interface{}
+	if decodeNumbers(raw, &value) == nil {
+		explanations := []string{}
+		for _, annotate := range annotators {
+			if explanation := annotate(path, value); len(explanation) > 0 {
+				explanations = append(explanations, explanation)
+			}
+		}
+		if len(explanations) > 0 {
+			comment = " //👈 " + strings.Join(explanations, "; ")
+		}
+	}
+
+	switch raw[0] {
+	case '{':
+		names, values := jsonMembers(raw)
+		if len(names) == 0 {
+			fmt.Fprintf(out, "%v%v{}%v%v\n", indent, prefix, comma, comment)
+			return
+		}
+		fmt.Fprintf(out, "%v%v{%v\n", indent, prefix, comment)
+		for i, name := range names {
+			writeAnnotated(out, values[i], MemberPath(path, name), indent+"  ", jsonString(name)+": ", i == len(names)-1, annotators)
+		}
+		fmt.Fprintf(out, "%v}%v\n", indent, comma)
+	case '[':
+		var elements []json.RawMessage
+		json.Unmarshal(raw, &elements)
+		if len(elements) == 0 {
+			fmt.Fprintf(out, "%v%v[]%v%v\n", indent, prefix, comma, comment)
+			return
+		}
+		fmt.Fprintf(out, "%v%v[%v\n", indent, prefix, comment)
+		for i, element := range elements {
+			writeAnnotated(out, element, fmt.Sprintf("%v[%v]", path, i), indent+"  ", "", i == len(elements)-1, annotators)
+		}
+		fmt.Fprintf(out, "%v]%v\n", indent, comma)
+	default:
+		fmt.Fprintf(out, "%v%v%v%v%v\n", indent, prefix, string(bytes.TrimSpace(raw)), comma, comment)
+	}
+}
+
+// The members of a JSON object in their original order
+func jsonMembers(raw json.RawMessage) ([]string, []json.RawMessage) {
+	names, values := []string{}, []json.RawMessage{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.Token() // {
+	for decoder.More() {
+		name, _ := decoder.Token()
+		var value json.RawMessage
+		if decoder.Decode(&value) != nil {
+			break
+		}
+		names, values = append(names, fmt.Sprint(name)), append(values, bytes.TrimSpace(value))
+	}
+	return names, values
+}
+
+func jsonString(value string) string {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.TrimSpace(out.String())
+}
+
+// Keep numbers as they are (e.g. large timestamps)
+func decodeNumbers(data []byte, target interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(target)
+}
+
+var plainMemberName = regexp.MustCompile(`^[^.\[\]"\s]+$`)
+
+// The path of a member of an object, e.g. "cnf.jkt"; names that aren't plain (e.g. URLs with
+// dots) are quoted in brackets, e.g. `events["http://schemas.openid.net/event/backchannel-logout"]`
+func MemberPath(path string, name string) string {
+	switch {
+	case !plainMemberName.MatchString(name):
+		return path + "[" + jsonString(name) + "]"
+	case len(path) == 0:
+		return name
+	}
+	return path + "." + name
+}
+
+// The name of the last member in a path (empty for array elements)
+func lastMemberName(path string) string {
+	if isArrayElement(path) {
+		return ""
+	}
+	if strings.HasSuffix(path, `"]`) {
+		var name string
+		if start := strings.LastIndex(path, `["`); start >= 0 && json.Unmarshal([]byte(path[start+1:len(path)-1]), &name) == nil {
+			return name
+		}
+	}
+	return path[strings.LastIndex(path, ".")+1:]
+}
+
+var arrayIndexPattern = regexp.MustCompile(`\[\d+\]`)
+var arrayElementPattern = regexp.MustCompile(`\[\d+\]$`)
+
+func isArrayElement(path string) bool {
+	return arrayElementPattern.MatchString(path)
+}
+
+// The path without array indices, e.g. "amr" for "amr[0]"
+func claimPath(path string) string {
+	return arrayIndexPattern.ReplaceAllString(path, "")
+}
+
+// Time claims with well-known names (RFC 7519, OIDC, Security Event Tokens, Azure's xms_tcdt)
+var timeClaimNames = map[string]bool{
+	"exp": true, "nbf": true, "iat": true, "auth_time": true, "updated_at": true,
+	"toe": true, "event_timestamp": true, "xms_tcdt": true,
+}
+
+// Epoch timestamps with the absolute and relative time; besides the well-known time claims
+// also numbers named "*_at", "*_time" or "*_timestamp" that are plausible timestamps
+func TimeAnnotator(now time.Time) Annotator {
+	return func(path string, value interface{}) string {
+		number, ok := value.(json.Number)
+		name := lastMemberName(path)
+		if !ok || len(name) == 0 {
+			return ""
+		}
+		epoch, err := number.Float64()
+		if err != nil || epoch < 0 {
+			return ""
+		}
+		if !timeClaimNames[name] {
+			suffixed := strings.HasSuffix(name, "_at") || strings.HasSuffix(name, "_time") || strings.HasSuffix(name, "_timestamp")
+			if !suffixed || epoch < 946684800 || epoch > 4102444800 { // 2000 to 2100
+				return ""
+			}
+		}
+		timestamp := time.Unix(int64(math.Floor(epoch)), 0)
+		return fmt.Sprintf("%v (%v)", timestamp, relativeTime(name, timestamp, now))
+	}
+}
+
+func relativeTime(name string, timestamp time.Time, now time.Time) string {
+	offset := timestamp.Sub(now)
+	switch {
+	case name == "exp" && offset <= 0:
+		return fmt.Sprintf("expired %v ago", CompactDuration(-offset))
+	case name == "exp":
+		return fmt.Sprintf("expires in %v", CompactDuration(offset))
+	case name == "nbf" && offset > 0:
+		return fmt.Sprintf("not valid before %v from now", CompactDuration(offset))
+	case offset <= 0:
+		return fmt.Sprintf("%v ago", CompactDuration(-offset))
+	}
+	return fmt.Sprintf("in %v", CompactDuration(offset))
+}
+
+// The two most significant units of a duration, e.g. "2d4h", "3h12m" or "45s"
+func CompactDuration(duration time.Duration) string {
+	seconds := int64(duration.Round(time.Second) / time.Second)
+	units := []struct {
+		name    string
+		seconds int64
+	}{{"d", 86400}, {"h", 3600}, {"m", 60}, {"s", 1}}
+	for i, unit := range units {
+		if seconds < unit.seconds {
+			continue
+		}
+		compact := fmt.Sprintf("%v%v", seconds/unit.seconds, unit.name)
+		if rest := seconds % unit.seconds; i+1 < len(units) && rest >= units[i+1].seconds {
+			compact += fmt.Sprintf("%v%v", rest/units[i+1].seconds, units[i+1].name)
+		}
+		return compact
+	}
+	return "0s"
+}
+
+// Explanations of claims by path (without array indices, e.g. "amr" or "cnf.jkt", see MemberPath) and value;
+// the empty value explains the claim itself, e.g. {"amr": {"pwd": "password"}, "sid": {"": "session ID"}}
+type ClaimDictionary map[string]map[string]string
+
+func (d ClaimDictionary) Annotator() Annotator {
+	return func(path string, value interface{}) string {
+		explanations, found := d[claimPath(path)]
+		if !found {
+			return ""
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+		default:
+			if explanation, found := explanations[fmt.Sprint(value)]; found {
+				return explanation
+			}
+		}
+		if isArrayElement(path) {
+			return "" // the claim itself is explained once, not per array element
+		}
+		return explanations[""]
+	}
+}
+
+// Claims of a vendor, applied to tokens of the given issuers (prefixes)
+type VendorDictionary struct {
+	Issuers []string
+	Claims  ClaimDictionary
+}
+
+// Annotators for a JWT payload; times, standard claims, the claims of the vendor that issued
+// the token and the additional dictionaries
+func ClaimAnnotators(payloadJson []byte, dictionaries ...ClaimDictionary) []Annotator {
+	annotators := []Annotator{TimeAnnotator(time.Now()), StandardClaims.Annotator()}
+	var claims struct {
+		Iss string `json:"iss"`
+	}
+	json.Unmarshal(payloadJson, &claims)
+	for _, vendor := range VendorClaims {
+		for _, issuer := range vendor.Issuers {
+			if len(claims.Iss) > 0 && strings.HasPrefix(claims.Iss, issuer) {
+				annotators = append(annotators, vendor.Claims.Annotator())
+				break
+			}
+		}
+	}
+	for _, dictionary := range dictionaries {
+		annotators = append(annotators, dictionary.Annotator())
+	}
+	return annotators
+}
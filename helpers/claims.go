@@ -0,0 +1,114 @@
+package helpers
+
+// Explanations of well-known claims and claim values (see ClaimDictionary)
+
+var StandardClaims = ClaimDictionary{
+	// 👉 https://datatracker.ietf.org/doc/html/rfc8176
+	"amr": {
+		"face":   "facial recognition",
+		"fpt":    "fingerprint",
+		"geo":    "geolocation",
+		"hwk":    "proof-of-possession of a hardware-secured key",
+		"iris":   "iris scan",
+		"kba":    "knowledge-based authentication",
+		"mca":    "multiple-channel authentication",
+		"mfa":    "multiple-factor authentication",
+		"otp":    "one-time password",
+		"pin":    "personal identification number or pattern",
+		"pop":    "proof-of-possession of a key",
+		"pwd":    "password",
+		"rba":    "risk-based authentication",
+		"retina": "retina scan",
+		"sc":     "smart card",
+		"sms":    "confirmation by SMS",
+		"swk":    "proof-of-possession of a software-secured key",
+		"tel":    "confirmation by telephone call",
+		"user":   "user presence test",
+		"vbm":    "voice biometric",
+		"wia":    "Windows integrated authentication",
+	},
+	"acr": {
+		"0":    "no assurance, e.g. authenticated by a long-lived browser cookie (OIDC)",
+		"phr":  "phishing-resistant authentication (FAPI)",
+		"phrh": "phishing-resistant authentication with a hardware-protected key (FAPI)",
+		"http://schemas.openid.net/pape/policies/2007/06/multi-factor":          "multi-factor authentication (PAPE)",
+		"http://schemas.openid.net/pape/policies/2007/06/multi-factor-physical": "multi-factor authentication with a physical device (PAPE)",
+		"http://schemas.openid.net/pape/policies/2007/06/phishing-resistant":    "phishing-resistant authentication (PAPE)",
+	},
+	// 👉 https://datatracker.ietf.org/doc/html/rfc7800
+	"cnf.jwk":      {"": "proof-of-possession key (RFC 7800)"},
+	"cnf.jkt":      {"": "token bound to a DPoP key by its JWK SHA-256 thumbprint (RFC 9449)"},
+	"cnf.x5t#S256": {"": "token bound to a client certificate by its SHA-256 thumbprint (mutual TLS, RFC 8705)"},
+	"act":          {"": "acting party, i.e. the token was obtained by delegation (RFC 8693)"},
+	"may_act":      {"": "party that may act on behalf of the subject (RFC 8693)"},
+	"sid":          {"": "session ID (OIDC front-/back-channel logout)"},
+	"at_hash":      {"": "hash of the access token issued with this ID token"},
+	"c_hash":       {"": "hash of the authorization code issued with this ID token"},
+	`events["http://schemas.openid.net/event/backchannel-logout"]`:                           {"": "logout token (OIDC back-channel logout)"},
+	`events["https://schemas.openid.net/secevent/caep/event-type/session-revoked"]`:          {"": "session revoked (CAEP)"},
+	`events["https://schemas.openid.net/secevent/caep/event-type/token-claims-change"]`:      {"": "token claims changed (CAEP)"},
+	`events["https://schemas.openid.net/secevent/caep/event-type/credential-change"]`:        {"": "credential changed (CAEP)"},
+	`events["https://schemas.openid.net/secevent/caep/event-type/assurance-level-change"]`:   {"": "assurance level changed (CAEP)"},
+	`events["https://schemas.openid.net/secevent/caep/event-type/device-compliance-change"]`: {"": "device compliance changed (CAEP)"},
+}
+
+// Vendor dictionaries by name
+var VendorClaims = map[string]VendorDictionary{
+	"azure": {
+		Issuers: []string{"https://login.microsoftonline.com/", "https://sts.windows.net/", "https://login.windows.net/"},
+		Claims:  azureClaims,
+	},
+}
+
+// 👉 https://learn.microsoft.com/en-us/entra/identity-platform/access-token-claims-reference
+var azureClaims = ClaimDictionary{
+	"tid":      {"": "tenant ID"},
+	"oid":      {"": "object ID of the user or service principal"},
+	"scp":      {"": "delegated permissions (scopes) of the client"},
+	"roles":    {"": "app roles assigned to the user or client (defined in the app registration)"},
+	"groups":   {"": "object IDs of the groups of the user"},
+	"xms_tcdt": {"": "tenant creation time"},
+	"idtyp": {
+		"app":  "app-only token (client credentials, no user)",
+		"user": "token of a user",
+	},
+	"appidacr": azureClientAuthentication,
+	"azpacr":   azureClientAuthentication,
+	"ver": {
+		"1.0": "v1.0 token",
+		"2.0": "v2.0 token",
+	},
+	// Built-in directory roles by template ID
+	// 👉 https://learn.microsoft.com/en-us/entra/identity/role-based-access-control/permissions-reference
+	"wids": {
+		"":                                     "directory roles of the user (by role template ID)",
+		"62e90394-69f5-4237-9190-012177145e10": "Global Administrator",
+		"f2ef992c-3afb-46b9-b7cf-a126ee74c451": "Global Reader",
+		"e8611ab8-c189-46e8-94e1-60213ab1f814": "Privileged Role Administrator",
+		"7be44c8a-adaf-4e2a-84d6-ab2649e08a13": "Privileged Authentication Administrator",
+		"c4e39bd9-1100-46d3-8c65-fb160da0071f": "Authentication Administrator",
+		"9b895d92-2cd3-44c7-9d02-a6ac2d5ea5c3": "Application Administrator",
+		"158c047a-c907-4556-b7ef-446551a6b5f7": "Cloud Application Administrator",
+		"cf1c38e5-3621-4004-a7cb-879624dced7c": "Application Developer",
+		"b1be1c3e-b65d-4f19-8427-f6fa0d97feb9": "Conditional Access Administrator",
+		"194ae4cb-b126-40b2-bd5b-6091b380977d": "Security Administrator",
+		"5d6b6bb7-de71-4623-b4af-96380a352509": "Security Reader",
+		"fe930be7-5e62-47db-91af-98c3a49a38b1": "User Administrator",
+		"729827e3-9c14-49f7-bb1b-9608f156bbb8": "Helpdesk Administrator",
+		"b0f54661-2d74-4c50-afa3-1ec803f12efe": "Billing Administrator",
+		"29232cdf-9323-42fd-ade2-1d097af3e4de": "Exchange Administrator",
+		"f28a1f50-f6e7-4571-818b-6a12f2af6b6c": "SharePoint Administrator",
+		"69091246-20e8-4a56-aa4d-066075b2a7a8": "Teams Administrator",
+		"3a2c62db-5318-420d-8d74-23affee5d9d5": "Intune Administrator",
+		"88d8e3e3-8f55-4a1e-953a-9b9898b8876b": "Directory Readers",
+		"d29b2b05-8046-44ba-8758-1e26182fcf32": "Directory Synchronization Accounts",
+		"b79fbf4d-3ef9-4689-8143-76b194e85509": "User (default role of members)",
+		"10dae51f-b6af-4016-8d66-8c2a99b929b3": "Guest User (default role of guests)",
+	},
+}
+
+var azureClientAuthentication = map[string]string{
+	"0": "public client (no client authentication)",
+	"1": "client authenticated with a client secret",
+	"2": "client authenticated with a certificate",
+}
@@ -5,10 +5,9 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"regexp"
-	"strconv"
+	"io"
+	"os"
 	"strings"
-	"time"
 )
 
 //"Unstructured object" (e.g. generic json)
@@ -44,7 +43,7 @@ func JwtToString(jwt string) string {
 	// A jwt shall have three sections separated by "." - we wan't the middle part
 	parts := strings.Split(jwt, ".")
 	if len(parts) == 3 {
-		body, err := base64.StdEncoding.DecodeString(Base64UrlToBase64(parts[1]))
+		body, err := Base64UrlDecode(parts[1])
 		if err == nil {
 			bodyStr = string(body)
 		}
@@ -52,6 +51,29 @@ func JwtToString(jwt string) string {
 	return bodyStr
 }
 
+// Extract/decode the body part as claims without any kind of authenticity verification
+func JwtClaims(jwt string) (Unstruct, error) {
+	var claims Unstruct
+	if err := json.Unmarshal(([]byte)(JwtToString(jwt)), &claims); err != nil {
+		return nil, fmt.Errorf("could not parse JWT claims: %v", err)
+	}
+	return claims, nil
+}
+
+// Tolerates padding and the standard alphabet (not allowed in JWTs, but seen in the wild)
+func Base64UrlDecode(input string) ([]byte, error) {
+	normalized := strings.TrimRight(strings.TrimSpace(input), "=")
+	normalized = strings.NewReplacer("+", "-", "/", "_").Replace(normalized)
+	if len(normalized)%4 == 1 {
+		return nil, fmt.Errorf("invalid base64url length (%v characters)", len(normalized))
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(normalized)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64url data: %v", err)
+	}
+	return decoded, nil
+}
+
 func Base64UrlToBase64(input string) string {
 	// https://stackoverflow.com/a/55389212
 	result := strings.ReplaceAll(input, "_", "/")
@@ -72,6 +94,20 @@ func Base64ToBase64Url(input string) string {
 	return result
 }
 
+// A value given as a literal string, @<file> or @- (stdin); nil if empty
+func ReadValueOrFile(value string) ([]byte, error) {
+	switch {
+	case value == "":
+		return nil, nil
+	case value == "@-":
+		return io.ReadAll(os.Stdin)
+	case strings.HasPrefix(value, "@"):
+		return os.ReadFile(value[1:])
+	default:
+		return ([]byte)(value), nil
+	}
+}
+
 func PrettyJson(jsonStr string) string {
 	// https://stackoverflow.com/a/29046984
 	var pretty bytes.Buffer
@@ -82,24 +118,3 @@ func PrettyJson(jsonStr string) string {
 	}
 	return pretty.String()
 }
-
-// Add jsonc-style comments with epoch interpretation, i.e. time strings
-// (fail silent for all kinds of error/non-founds)
-func InjectEpochFieldComments(jsonStr string, epochKeys []string) string {
-	resultStr := jsonStr
-	for _, keyName := range epochKeys {
-		parsePattern := fmt.Sprintf(`"%v": (?P<Epoch>\d+),?`, keyName)
-		parser := regexp.MustCompile(parsePattern)
-		matches := parser.FindStringSubmatch(jsonStr)
-		if len(matches) == 2 {
-			original := matches[0]
-			epoch, err := strconv.ParseInt(matches[1], 10, 64)
-			if err == nil {
-				dateStr := time.Unix(epoch, 0)
-				replaced := fmt.Sprintf("%v //👈 %v", original, dateStr)
-				resultStr = strings.Replace(resultStr, original, replaced, 1)
-			}
-		}
-	}
-	return resultStr
-}
@@ -0,0 +1,765 @@
+package helpers
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Minimal JOSE support (JWS signing and verification, JWE decryption and JWK/JWKS/PEM keys)
+// 👉 https://datatracker.ietf.org/doc/html/rfc7515 (JWS)
+// 👉 https://datatracker.ietf.org/doc/html/rfc7516 (JWE)
+// 👉 https://datatracker.ietf.org/doc/html/rfc7517 (JWK)
+// 👉 https://datatracker.ietf.org/doc/html/rfc7518 (algorithms)
+
+type JoseHeader struct {
+	Alg     string   `json:"alg"`
+	Enc     string   `json:"enc,omitempty"`
+	Kid     string   `json:"kid,omitempty"`
+	Typ     string   `json:"typ,omitempty"`
+	Cty     string   `json:"cty,omitempty"`
+	Zip     string   `json:"zip,omitempty"`
+	Jku     string   `json:"jku,omitempty"`
+	X5u     string   `json:"x5u,omitempty"`
+	X5c     []string `json:"x5c,omitempty"`
+	X5t     string   `json:"x5t,omitempty"`
+	X5tS256 string   `json:"x5t#S256,omitempty"`
+	Jwk     *Jwk     `json:"jwk,omitempty"`
+	Epk     *Jwk     `json:"epk,omitempty"`
+	Apu     string   `json:"apu,omitempty"`
+	Apv     string   `json:"apv,omitempty"`
+}
+
+type Jwk struct {
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid,omitempty"`
+	Use string   `json:"use,omitempty"`
+	Alg string   `json:"alg,omitempty"`
+	Crv string   `json:"crv,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	D   string   `json:"d,omitempty"`
+	P   string   `json:"p,omitempty"`
+	Q   string   `json:"q,omitempty"`
+	K   string   `json:"k,omitempty"`
+	X5c []string `json:"x5c,omitempty"`
+}
+
+type Jwks struct {
+	Keys []Jwk `json:"keys"`
+}
+
+// Split a compact serialization (3 parts for JWS, 5 for JWE) and decode its header
+func ParseJoseHeader(token string) (JoseHeader, []string, error) {
+	var header JoseHeader
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 && len(parts) != 5 {
+		return header, parts, fmt.Errorf("expected 3 (JWS) or 5 (JWE) dot-separated segments, got %v", len(parts))
+	}
+	headerBytes, err := Base64UrlDecode(parts[0])
+	if err != nil {
+		return header, parts, fmt.Errorf("invalid header segment: %v", err)
+	}
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return header, parts, fmt.Errorf("invalid header JSON: %v", err)
+	}
+	return header, parts, nil
+}
+
+// The decoded sections of a JWS (compact serialization)
+type JwtSections struct {
+	Header       JoseHeader
+	HeaderJson   []byte
+	PayloadJson  []byte
+	Signature    []byte
+	SigningInput string
+	Encryption   []JweLayer // of an encrypted JWT (outermost first), see DecodeNestedJwt
+}
+
+type JweLayer struct {
+	Header     JoseHeader
+	HeaderJson []byte
+}
+
+// Decode all sections of a JWS with errors that tell which part is malformed
+func DecodeJwt(token string) (JwtSections, error) {
+	var sections JwtSections
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	switch {
+	case len(parts) == 5:
+		return sections, fmt.Errorf("the token has 5 segments, i.e. it is encrypted (JWE)")
+	case len(parts) != 3:
+		return sections, fmt.Errorf("expected 3 dot-separated segments (header.payload.signature), got %v", len(parts))
+	}
+	var err error
+	names := []string{"header", "payload", "signature"}
+	decoded := make([][]byte, 3)
+	for i, part := range parts {
+		if decoded[i], err = Base64UrlDecode(part); err != nil {
+			return sections, fmt.Errorf("malformed %v segment: %v", names[i], err)
+		}
+	}
+	for i := range names[:2] {
+		if !json.Valid(decoded[i]) {
+			return sections, fmt.Errorf("malformed %v segment: not JSON", names[i])
+		}
+	}
+	if err := json.Unmarshal(decoded[0], &sections.Header); err != nil {
+		return sections, fmt.Errorf("malformed header segment: %v", err)
+	}
+	sections.HeaderJson, sections.PayloadJson, sections.Signature = decoded[0], decoded[1], decoded[2]
+	sections.SigningInput = parts[0] + "." + parts[1]
+	return sections, nil
+}
+
+// Decode a JWT that may be encrypted (JWE) with the given decryption key; a nested JWT
+// (cty: JWT) is decoded recursively, otherwise the plaintext is the payload of the JWE
+func DecodeNestedJwt(token string, key interface{}) (JwtSections, error) {
+	layers := []JweLayer{}
+	for strings.Count(strings.TrimSpace(token), ".") == 4 {
+		header, parts, err := ParseJoseHeader(token)
+		if err != nil {
+			return JwtSections{Encryption: layers}, err
+		}
+		headerJson, _ := Base64UrlDecode(parts[0])
+		layers = append(layers, JweLayer{Header: header, HeaderJson: headerJson})
+		plaintext, _, err := DecryptJwe(token, key)
+		if err != nil {
+			return JwtSections{Encryption: layers}, fmt.Errorf("could not decrypt JWE: %v", err)
+		}
+		if !strings.EqualFold(header.Cty, "JWT") && json.Valid(plaintext) {
+			return JwtSections{Header: header, HeaderJson: headerJson, PayloadJson: plaintext, Encryption: layers}, nil
+		}
+		token = string(plaintext)
+	}
+	sections, err := DecodeJwt(token)
+	if err != nil && len(layers) > 0 {
+		err = fmt.Errorf("nested JWT: %v", err)
+	}
+	sections.Encryption = layers
+	return sections, err
+}
+
+// Verify the signature of a JWS with a public key (or the public part of a private key) or an
+// HMAC secret ([]byte) and return the payload
+func VerifyJws(token string, key interface{}) ([]byte, error) {
+	header, parts, err := ParseJoseHeader(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("not a JWS (signed JWT)")
+	}
+	signature, err := Base64UrlDecode(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature segment: %v", err)
+	}
+	if err := verifySignature(header.Alg, []byte(parts[0]+"."+parts[1]), signature, key); err != nil {
+		return nil, err
+	}
+	return Base64UrlDecode(parts[1])
+}
+
+func verifySignature(alg string, signingInput []byte, signature []byte, key interface{}) error {
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+	hash, err := algHash(alg)
+	if err != nil {
+		return err
+	}
+	digest := func() []byte {
+		hasher := hash.New()
+		hasher.Write(signingInput)
+		return hasher.Sum(nil)
+	}
+
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("algorithm %v requires a symmetric key", alg)
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signingInput)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case "RS", "PS":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %v requires an RSA key", alg)
+		}
+		if alg[:2] == "RS" {
+			err = rsa.VerifyPKCS1v15(publicKey, hash, digest(), signature)
+		} else {
+			err = rsa.VerifyPSS(publicKey, hash, digest(), signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case "ES":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %v requires an EC key", alg)
+		}
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature length for %v", alg)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(publicKey, digest(), r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case "Ed":
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %v requires an Ed25519 key", alg)
+		}
+		if !ed25519.Verify(publicKey, signingInput, signature) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// Sign a payload (compact serialization) with a private key or an HMAC secret ([]byte); the
+// algorithm is added to the header
+func SignJws(header Unstruct, payload []byte, alg string, key interface{}) (string, error) {
+	header["alg"] = alg
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("could not encode header: %v", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := createSignature(alg, []byte(signingInput), key)
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func createSignature(alg string, signingInput []byte, key interface{}) ([]byte, error) {
+	hash, err := algHash(alg)
+	if err != nil {
+		return nil, err
+	}
+	hasher := hash.New()
+	hasher.Write(signingInput)
+	digest := hasher.Sum(nil)
+
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %v requires a symmetric key", alg)
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	case "RS", "PS":
+		privateKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %v requires an RSA private key", alg)
+		}
+		if alg[:2] == "RS" {
+			return rsa.SignPKCS1v15(rand.Reader, privateKey, hash, digest)
+		}
+		return rsa.SignPSS(rand.Reader, privateKey, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES":
+		privateKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %v requires an EC private key", alg)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest)
+		if err != nil {
+			return nil, err
+		}
+		size := (privateKey.Curve.Params().BitSize + 7) / 8
+		return append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...), nil
+	case "Ed":
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %v requires an Ed25519 private key", alg)
+		}
+		return ed25519.Sign(privateKey, signingInput), nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// The default signature algorithm for a key
+func DefaultAlgorithm(key interface{}) (string, error) {
+	switch k := key.(type) {
+	case []byte:
+		return "HS256", nil
+	case *rsa.PrivateKey:
+		return "RS256", nil
+	case *ecdsa.PrivateKey:
+		return map[int]string{256: "ES256", 384: "ES384", 521: "ES512"}[k.Curve.Params().BitSize], nil
+	case ed25519.PrivateKey:
+		return "EdDSA", nil
+	}
+	return "", fmt.Errorf("not a private key (or secret) that can be used for signing")
+}
+
+// The hash function of a signature algorithm (EdDSA signs the input as is)
+func algHash(alg string) (crypto.Hash, error) {
+	if alg == "EdDSA" {
+		return crypto.SHA512, nil
+	}
+	if alg == "none" {
+		return 0, fmt.Errorf("unsecured JWT (alg: none) has no signature to verify")
+	}
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	if hash, found := hashes[strings.TrimLeft(alg, "HRPES")]; found && len(alg) == 5 {
+		switch alg[:2] {
+		case "HS", "RS", "PS", "ES":
+			return hash, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// Decrypt a JWE with a private key (or a symmetric key as []byte) and return the plaintext
+func DecryptJwe(token string, key interface{}) ([]byte, JoseHeader, error) {
+	header, parts, err := ParseJoseHeader(token)
+	if err != nil {
+		return nil, header, err
+	}
+	if len(parts) != 5 {
+		return nil, header, fmt.Errorf("not a JWE (encrypted JWT)")
+	}
+	segments := make([][]byte, 5)
+	for i, part := range parts[1:] {
+		if segments[i+1], err = Base64UrlDecode(part); err != nil {
+			return nil, header, fmt.Errorf("invalid JWE segment %v: %v", i+2, err)
+		}
+	}
+	encryptedKey, iv, ciphertext, tag := segments[1], segments[2], segments[3], segments[4]
+
+	cek, err := decryptContentKey(header, encryptedKey, key)
+	if err != nil {
+		return nil, header, err
+	}
+	plaintext, err := decryptContent(header.Enc, cek, iv, ciphertext, tag, []byte(parts[0]))
+	if err != nil {
+		return nil, header, err
+	}
+	if header.Zip == "DEF" {
+		if plaintext, err = io.ReadAll(flate.NewReader(bytes.NewReader(plaintext))); err != nil {
+			return nil, header, fmt.Errorf("could not decompress JWE plaintext: %v", err)
+		}
+	}
+	return plaintext, header, nil
+}
+
+// Content encryption key (CEK) according to the key management algorithm
+func decryptContentKey(header JoseHeader, encryptedKey []byte, key interface{}) ([]byte, error) {
+	switch header.Alg {
+	case "RSA-OAEP", "RSA-OAEP-256":
+		privateKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %v requires an RSA private key", header.Alg)
+		}
+		hash := sha1.New()
+		if header.Alg == "RSA-OAEP-256" {
+			hash = sha256.New()
+		}
+		cek, err := rsa.DecryptOAEP(hash, nil, privateKey, encryptedKey, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt content encryption key: %v", err)
+		}
+		return cek, nil
+	case "A128KW", "A192KW", "A256KW":
+		secret, ok := key.([]byte)
+		if !ok || len(secret)*8 != encKeyBits(header.Alg) {
+			return nil, fmt.Errorf("algorithm %v requires a symmetric key of %v bits", header.Alg, encKeyBits(header.Alg))
+		}
+		return aesKeyUnwrap(secret, encryptedKey)
+	case "dir":
+		secret, ok := key.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("algorithm dir requires a symmetric key")
+		}
+		if len(encryptedKey) > 0 {
+			return nil, fmt.Errorf("unexpected encrypted key with algorithm dir")
+		}
+		return secret, nil
+	case "ECDH-ES", "ECDH-ES+A128KW", "ECDH-ES+A192KW", "ECDH-ES+A256KW":
+		sharedSecret, err := ecdhSharedSecret(header.Epk, key)
+		if err != nil {
+			return nil, err
+		}
+		// Direct key agreement derives the CEK itself, otherwise the key to unwrap the CEK
+		if header.Alg == "ECDH-ES" {
+			if len(encryptedKey) > 0 {
+				return nil, fmt.Errorf("unexpected encrypted key with algorithm ECDH-ES")
+			}
+			return concatKdf(sharedSecret, header.Enc, encKeyBits(header.Enc), header)
+		}
+		kek, err := concatKdf(sharedSecret, header.Alg, encKeyBits(strings.TrimPrefix(header.Alg, "ECDH-ES+")), header)
+		if err != nil {
+			return nil, err
+		}
+		return aesKeyUnwrap(kek, encryptedKey)
+	}
+	return nil, fmt.Errorf("unsupported key management algorithm %q", header.Alg)
+}
+
+// Shared secret (the x coordinate) of the ephemeral public key ("epk") and an EC private key
+func ecdhSharedSecret(epk *Jwk, key interface{}) ([]byte, error) {
+	privateKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("ECDH-ES requires an EC private key")
+	}
+	if epk == nil || epk.Kty != "EC" {
+		return nil, fmt.Errorf("missing or unsupported ephemeral public key (\"epk\" header)")
+	}
+	ephemeralKey, err := epk.Key(false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral public key: %v", err)
+	}
+	publicKey := ephemeralKey.(*ecdsa.PublicKey)
+	if publicKey.Curve.Params().Name != privateKey.Curve.Params().Name {
+		return nil, fmt.Errorf("ephemeral public key (%v) doesn't match the curve of the private key (%v)", publicKey.Curve.Params().Name, privateKey.Curve.Params().Name)
+	}
+	x, _ := privateKey.Curve.ScalarMult(publicKey.X, publicKey.Y, privateKey.D.Bytes())
+	return x.FillBytes(make([]byte, (privateKey.Curve.Params().BitSize+7)/8)), nil
+}
+
+// Concat KDF with SHA-256 (NIST SP 800-56A) as used for ECDH-ES
+// 👉 https://datatracker.ietf.org/doc/html/rfc7518#section-4.6.2
+func concatKdf(sharedSecret []byte, algorithmId string, bits int, header JoseHeader) ([]byte, error) {
+	if bits <= 0 {
+		return nil, fmt.Errorf("unsupported algorithm %q", algorithmId)
+	}
+	apu, err := Base64UrlDecode(header.Apu)
+	if err != nil {
+		return nil, fmt.Errorf("invalid \"apu\" header: %v", err)
+	}
+	apv, err := Base64UrlDecode(header.Apv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid \"apv\" header: %v", err)
+	}
+	var otherInfo bytes.Buffer
+	for _, field := range [][]byte{[]byte(algorithmId), apu, apv} {
+		binary.Write(&otherInfo, binary.BigEndian, uint32(len(field)))
+		otherInfo.Write(field)
+	}
+	binary.Write(&otherInfo, binary.BigEndian, uint32(bits))
+
+	derived := []byte{}
+	for counter := uint32(1); len(derived)*8 < bits; counter++ {
+		digest := sha256.New()
+		binary.Write(digest, binary.BigEndian, counter)
+		digest.Write(sharedSecret)
+		digest.Write(otherInfo.Bytes())
+		derived = digest.Sum(derived)
+	}
+	return derived[:bits/8], nil
+}
+
+// AES Key Wrap (RFC 3394), i.e. A128KW, A192KW and A256KW
+func aesKeyUnwrap(kek []byte, wrapped []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encryption key: %v", err)
+	}
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, fmt.Errorf("invalid length of encrypted key")
+	}
+	n := len(wrapped)/8 - 1
+	integrity := make([]byte, 8)
+	copy(integrity, wrapped[:8])
+	key := make([]byte, n*8)
+	copy(key, wrapped[8:])
+	buffer := make([]byte, aes.BlockSize)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(buffer[:8], binary.BigEndian.Uint64(integrity)^uint64(n*j+i))
+			copy(buffer[8:], key[(i-1)*8:i*8])
+			block.Decrypt(buffer, buffer)
+			copy(integrity, buffer[:8])
+			copy(key[(i-1)*8:i*8], buffer[8:])
+		}
+	}
+	if !bytes.Equal(integrity, bytes.Repeat([]byte{0xA6}, 8)) {
+		return nil, fmt.Errorf("could not unwrap content encryption key (wrong key?)")
+	}
+	return key, nil
+}
+
+func decryptContent(enc string, cek []byte, iv []byte, ciphertext []byte, tag []byte, aad []byte) ([]byte, error) {
+	switch enc {
+	case "A128GCM", "A192GCM", "A256GCM":
+		if len(cek)*8 != encKeyBits(enc) {
+			return nil, fmt.Errorf("invalid key length for %v", enc)
+		}
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), aad)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt content (wrong key?)")
+		}
+		return plaintext, nil
+	case "A128CBC-HS256", "A192CBC-HS384", "A256CBC-HS512":
+		// The first half of the CEK is the MAC key, the second half the encryption key
+		// 👉 https://datatracker.ietf.org/doc/html/rfc7518#section-5.2
+		if len(cek)*8 != encKeyBits(enc) {
+			return nil, fmt.Errorf("invalid key length for %v", enc)
+		}
+		macKey, encKey := cek[:len(cek)/2], cek[len(cek)/2:]
+		hashes := map[int]crypto.Hash{16: crypto.SHA256, 24: crypto.SHA384, 32: crypto.SHA512}
+		mac := hmac.New(hashes[len(macKey)].New, macKey)
+		mac.Write(aad)
+		mac.Write(iv)
+		mac.Write(ciphertext)
+		binary.Write(mac, binary.BigEndian, uint64(len(aad)*8))
+		if !hmac.Equal(mac.Sum(nil)[:len(macKey)], tag) {
+			return nil, fmt.Errorf("could not decrypt content (wrong key?)")
+		}
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, fmt.Errorf("invalid initialization vector or ciphertext length")
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+		padding := int(plaintext[len(plaintext)-1])
+		if padding == 0 || padding > aes.BlockSize {
+			return nil, fmt.Errorf("invalid padding of decrypted content")
+		}
+		return plaintext[:len(plaintext)-padding], nil
+	}
+	return nil, fmt.Errorf("unsupported content encryption algorithm %q", enc)
+}
+
+// Key length in bits, e.g. 128 for "A128GCM" or "A128KW" and 256 for "A128CBC-HS256" (the
+// CEK includes the MAC key)
+func encKeyBits(enc string) int {
+	var bits int
+	fmt.Sscanf(strings.TrimPrefix(enc, "A"), "%d", &bits)
+	if strings.Contains(enc, "CBC-HS") {
+		bits *= 2
+	}
+	return bits
+}
+
+// Key material of a JWK; the private key if available (and requested), otherwise the public
+// key ([]byte for symmetric keys)
+func (k Jwk) Key(private bool) (interface{}, error) {
+	decode := func(value string) *big.Int {
+		bytes, _ := Base64UrlDecode(value)
+		return new(big.Int).SetBytes(bytes)
+	}
+	switch k.Kty {
+	case "oct":
+		return Base64UrlDecode(k.K)
+	case "RSA":
+		publicKey := rsa.PublicKey{N: decode(k.N), E: int(decode(k.E).Int64())}
+		if publicKey.N.Sign() == 0 || publicKey.E == 0 {
+			return nil, fmt.Errorf("invalid RSA key")
+		}
+		if !private || len(k.D) == 0 {
+			return &publicKey, nil
+		}
+		privateKey := &rsa.PrivateKey{PublicKey: publicKey, D: decode(k.D)}
+		if len(k.P) > 0 && len(k.Q) > 0 {
+			privateKey.Primes = []*big.Int{decode(k.P), decode(k.Q)}
+			privateKey.Precompute()
+		}
+		return privateKey, nil
+	case "EC":
+		curve, err := namedCurve(k.Crv)
+		if err != nil {
+			return nil, err
+		}
+		publicKey := ecdsa.PublicKey{Curve: curve, X: decode(k.X), Y: decode(k.Y)}
+		if !curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, fmt.Errorf("invalid EC key (not on curve %v)", k.Crv)
+		}
+		if !private || len(k.D) == 0 {
+			return &publicKey, nil
+		}
+		return &ecdsa.PrivateKey{PublicKey: publicKey, D: decode(k.D)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		if private && len(k.D) > 0 {
+			seed, err := Base64UrlDecode(k.D)
+			if err != nil || len(seed) != ed25519.SeedSize {
+				return nil, fmt.Errorf("invalid Ed25519 private key")
+			}
+			return ed25519.NewKeyFromSeed(seed), nil
+		}
+		x, err := Base64UrlDecode(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func namedCurve(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("unsupported curve %q", crv)
+}
+
+// A JWK Set, or a single JWK treated as a set with one key
+func ParseJwks(data []byte) (Jwks, error) {
+	var jwks Jwks
+	if err := json.Unmarshal(data, &jwks); err == nil && len(jwks.Keys) > 0 {
+		return jwks, nil
+	}
+	var jwk Jwk
+	if err := json.Unmarshal(data, &jwk); err != nil || len(jwk.Kty) == 0 {
+		return jwks, fmt.Errorf("neither a JWK nor a JWK Set")
+	}
+	return Jwks{Keys: []Jwk{jwk}}, nil
+}
+
+// Don't hang on an unresponsive endpoint
+const jwksFetchTimeout = 30 * time.Second
+
+func FetchJwks(jwksUrl string) (Jwks, error) {
+	httpClient := http.Client{Timeout: jwksFetchTimeout}
+	res, err := httpClient.Get(jwksUrl)
+	if err != nil {
+		return Jwks{}, fmt.Errorf("could not fetch JWKS: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Jwks{}, fmt.Errorf("unexpected status code for %v: %v", jwksUrl, res.StatusCode)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Jwks{}, fmt.Errorf("could not read JWKS: %v", err)
+	}
+	return ParseJwks(body)
+}
+
+// Candidate keys for a token; matching "kid" (if given) and compatible with the algorithm
+func (s Jwks) Select(kid string, alg string) []Jwk {
+	candidates := []Jwk{}
+	for _, key := range s.Keys {
+		if len(kid) > 0 && len(key.Kid) > 0 && key.Kid != kid {
+			continue
+		}
+		if len(key.Alg) > 0 && len(alg) > 0 && key.Alg != alg {
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+	return candidates
+}
+
+// Verify a JWS against a JWK Set (trying each candidate key) and return the payload
+func VerifyJwsWithJwks(token string, jwks Jwks) ([]byte, Jwk, error) {
+	header, _, err := ParseJoseHeader(token)
+	if err != nil {
+		return nil, Jwk{}, err
+	}
+	candidates := jwks.Select(header.Kid, header.Alg)
+	if len(candidates) == 0 {
+		return nil, Jwk{}, fmt.Errorf("no key in JWKS matches kid %q and alg %q", header.Kid, header.Alg)
+	}
+	err = fmt.Errorf("no usable key")
+	for _, candidate := range candidates {
+		key, keyErr := candidate.Key(false)
+		if keyErr != nil {
+			err = keyErr
+			continue
+		}
+		payload, verifyErr := VerifyJws(token, key)
+		if verifyErr == nil {
+			return payload, candidate, nil
+		}
+		err = verifyErr
+	}
+	return nil, Jwk{}, err
+}
+
+// A key in PEM or JWK format (the private key if available)
+func ParseKey(data []byte) (interface{}, error) {
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		return ParsePemKey(data)
+	}
+	var jwk Jwk
+	if err := json.Unmarshal(data, &jwk); err != nil || len(jwk.Kty) == 0 {
+		return nil, fmt.Errorf("neither a PEM key nor a JWK")
+	}
+	return jwk.Key(true)
+}
+
+// A key in PEM format; private key (PKCS#1, PKCS#8 or SEC 1), public key (PKIX or PKCS#1)
+// or the public key of a certificate
+func ParsePemKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM type %q", block.Type)
+}
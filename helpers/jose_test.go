@@ -0,0 +1,278 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"strings"
+	"testing"
+)
+
+// Signatures from RFC 7515 Appendix A (the payload is the same for all examples)
+// 👉 https://datatracker.ietf.org/doc/html/rfc7515#appendix-A
+const rfc7515Payload = "eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ"
+
+func TestVerifyJwsKnownAnswers(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		jwk   Jwk
+	}{
+		{
+			name:  "A.1 HS256",
+			token: "eyJ0eXAiOiJKV1QiLA0KICJhbGciOiJIUzI1NiJ9." + rfc7515Payload + ".dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+			jwk:   Jwk{Kty: "oct", K: "AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow"},
+		},
+		{
+			name:  "A.3 ES256",
+			token: "eyJhbGciOiJFUzI1NiJ9." + rfc7515Payload + ".DtEhU3ljbEg8L38VWAfUAqOyKAM6-Xx-F4GawxaepmXFCgfTjDxw5djxLa8ISlSApmWQxfKTUJqPP3-Kg6NU1Q",
+			jwk:   Jwk{Kty: "EC", Crv: "P-256", X: "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU", Y: "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := test.jwk.Key(false)
+			if err != nil {
+				t.Fatalf("invalid key: %v", err)
+			}
+			payload, err := VerifyJws(test.token, key)
+			if err != nil {
+				t.Fatalf("verification failed: %v", err)
+			}
+			if !strings.Contains(string(payload), `"iss":"joe"`) {
+				t.Errorf("unexpected payload: %s", payload)
+			}
+			tampered := test.token[:len(test.token)-4] + "AAAA"
+			if _, err := VerifyJws(tampered, key); err == nil {
+				t.Errorf("tampered signature accepted")
+			}
+		})
+	}
+}
+
+func TestSignAndVerifyJws(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p521Key, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+
+	tests := []struct {
+		alg string
+		key interface{}
+	}{
+		{"HS256", secret},
+		{"HS384", secret},
+		{"HS512", secret},
+		{"RS256", rsaKey},
+		{"RS384", rsaKey},
+		{"RS512", rsaKey},
+		{"PS256", rsaKey},
+		{"PS384", rsaKey},
+		{"PS512", rsaKey},
+		{"ES256", p256Key},
+		{"ES384", p384Key},
+		{"ES512", p521Key},
+		{"EdDSA", edKey},
+	}
+	payload := []byte(`{"sub":"test"}`)
+	for _, test := range tests {
+		t.Run(test.alg, func(t *testing.T) {
+			token, err := SignJws(Unstruct{"typ": "JWT"}, payload, test.alg, test.key)
+			if err != nil {
+				t.Fatalf("signing failed: %v", err)
+			}
+			verified, err := VerifyJws(token, test.key) // the public part of the private key
+			if err != nil {
+				t.Fatalf("verification failed: %v", err)
+			}
+			if string(verified) != string(payload) {
+				t.Errorf("unexpected payload: %s", verified)
+			}
+
+			parts := strings.Split(token, ".")
+			tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]
+			if _, err := VerifyJws(tampered, test.key); err == nil {
+				t.Errorf("tampered payload accepted")
+			}
+		})
+	}
+
+	t.Run("wrong key type", func(t *testing.T) {
+		token, _ := SignJws(Unstruct{}, payload, "RS256", rsaKey)
+		if _, err := VerifyJws(token, p256Key); err == nil {
+			t.Errorf("RS256 token accepted with an EC key")
+		}
+		if _, err := SignJws(Unstruct{}, payload, "ES256", rsaKey); err == nil {
+			t.Errorf("ES256 signature created with an RSA key")
+		}
+	})
+}
+
+// Known-answer tests for the hand-rolled parts of the JWE decryption, i.e. AES key wrap,
+// Concat KDF and AES-CBC with HMAC (AES-GCM and RSA-OAEP are from the standard library)
+
+func TestAesKeyUnwrap(t *testing.T) {
+	// 👉 https://datatracker.ietf.org/doc/html/rfc3394#section-4
+	tests := []struct {
+		name    string
+		kek     string
+		wrapped string
+		key     string
+	}{
+		{"4.1 128 bits of key data with a 128-bit KEK", "000102030405060708090A0B0C0D0E0F",
+			"1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5", "00112233445566778899AABBCCDDEEFF"},
+		{"4.2 128 bits of key data with a 192-bit KEK", "000102030405060708090A0B0C0D0E0F1011121314151617",
+			"96778B25AE6CA435F92B5B97C050AED2468AB8A17AD84E5D", "00112233445566778899AABBCCDDEEFF"},
+		{"4.3 128 bits of key data with a 256-bit KEK", "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"64E8C3F9CE0F5BA263E9777905818A2A93C8191E7D6E8AE7", "00112233445566778899AABBCCDDEEFF"},
+		{"4.4 192 bits of key data with a 192-bit KEK", "000102030405060708090A0B0C0D0E0F1011121314151617",
+			"031D33264E15D33268F24EC260743EDCE1C6C7DDEE725A936BA814915C6762D2", "00112233445566778899AABBCCDDEEFF0001020304050607"},
+		{"4.5 192 bits of key data with a 256-bit KEK", "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"A8F9BC1612C68B3FF6E6F4FBE30E71E4769C8B80A32CB8958CD5D17D6B254DA1", "00112233445566778899AABBCCDDEEFF0001020304050607"},
+		{"4.6 256 bits of key data with a 256-bit KEK", "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21", "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wrapped := mustDecodeHex(t, test.wrapped)
+			key, err := aesKeyUnwrap(mustDecodeHex(t, test.kek), wrapped)
+			if err != nil {
+				t.Fatalf("unwrap failed: %v", err)
+			}
+			if expected := mustDecodeHex(t, test.key); !bytes.Equal(key, expected) {
+				t.Errorf("unexpected key %X (expected: %X)", key, expected)
+			}
+			wrapped[len(wrapped)-1] ^= 1
+			if _, err := aesKeyUnwrap(mustDecodeHex(t, test.kek), wrapped); err == nil {
+				t.Errorf("tampered wrapped key accepted")
+			}
+		})
+	}
+}
+
+func TestEcdhEsKeyAgreement(t *testing.T) {
+	// 👉 https://datatracker.ietf.org/doc/html/rfc7518#appendix-C
+	alice := Jwk{Kty: "EC", Crv: "P-256",
+		X: "gI0GAILBdu7T53akrFmMyGcsF3n5dO7MmwNBHKW5SV0",
+		Y: "SLW_xSffzlPWrHEVI30DHM_4egVwt3NQqeUD7nMFpps"}
+	bob := Jwk{Kty: "EC", Crv: "P-256",
+		X: "weNJy2HscCSM6AEDTDg04biOvhFhyyWvOHQfeF_PxMQ",
+		Y: "e8lnCO-AlStT-NJVX-crhB7QRYhiix03illJOVAOyck",
+		D: "VEmDZpDXXK8p8N0Cndsxs924q6nS1RXFASRl6BfUqdw"}
+	header := JoseHeader{Alg: "ECDH-ES", Enc: "A128GCM", Apu: "QWxpY2U", Apv: "Qm9i", Epk: &alice}
+
+	bobKey, err := bob.Key(true)
+	if err != nil {
+		t.Fatalf("invalid key: %v", err)
+	}
+	sharedSecret, err := ecdhSharedSecret(header.Epk, bobKey)
+	if err != nil {
+		t.Fatalf("key agreement failed: %v", err)
+	}
+	expectedSecret := []byte{158, 86, 217, 29, 129, 113, 53, 211, 114, 131, 66, 131, 191, 132, 38, 156,
+		251, 49, 110, 163, 218, 128, 106, 72, 246, 218, 167, 121, 140, 254, 144, 196}
+	if !bytes.Equal(sharedSecret, expectedSecret) {
+		t.Fatalf("unexpected shared secret %v", sharedSecret)
+	}
+
+	derived, err := concatKdf(sharedSecret, header.Enc, encKeyBits(header.Enc), header)
+	if err != nil {
+		t.Fatalf("key derivation failed: %v", err)
+	}
+	if encoded := base64.RawURLEncoding.EncodeToString(derived); encoded != "VqqN6vgjbSBcIijNcacQGg" {
+		t.Errorf("unexpected derived key %v", encoded)
+	}
+}
+
+func TestDecryptJweKnownAnswers(t *testing.T) {
+	// 👉 https://datatracker.ietf.org/doc/html/rfc7516#appendix-A.3 (A128KW and A128CBC-HS256)
+	token := "eyJhbGciOiJBMTI4S1ciLCJlbmMiOiJBMTI4Q0JDLUhTMjU2In0." +
+		"6KB707dM9YTIgHtLvtgWQ8mKwboJW3of9locizkDTHzBC2IlrT1oOQ." +
+		"AxY8DCtDaGlsbGljb3RoZQ." +
+		"KDlTtXchhZTGufMYmOYGS4HffxPSUrfmqCHXaI9wOGY." +
+		"U0m_YmjN04DJvceFICbCVQ"
+	key, _ := Base64UrlDecode("GawgguFyGrWKav7AX4VKUg")
+	plaintext, _, err := DecryptJwe(token, key)
+	if err != nil {
+		t.Fatalf("decryption failed: %v", err)
+	}
+	if string(plaintext) != "Live long and prosper." {
+		t.Errorf("unexpected plaintext %q", plaintext)
+	}
+
+	tampered := strings.Replace(token, ".U0m_", ".U0m-", 1)
+	if _, _, err := DecryptJwe(tampered, key); err == nil {
+		t.Errorf("tampered authentication tag accepted")
+	}
+	wrongKey, _ := Base64UrlDecode("AAAAAAAAAAAAAAAAAAAAAA")
+	if _, _, err := DecryptJwe(token, wrongKey); err == nil {
+		t.Errorf("wrong key accepted")
+	}
+}
+
+func TestDecryptContentKnownAnswers(t *testing.T) {
+	// 👉 https://datatracker.ietf.org/doc/html/rfc7516#appendix-A.1 (content encryption with A256GCM)
+	cek := []byte{177, 161, 244, 128, 84, 143, 225, 115, 63, 180, 3, 255, 107, 154, 212, 246,
+		138, 7, 110, 91, 112, 46, 34, 105, 47, 130, 203, 46, 122, 234, 64, 252}
+	iv, _ := Base64UrlDecode("48V1_ALb6US04U3b")
+	ciphertext, _ := Base64UrlDecode("5eym8TW_c8SuK0ltJ3rpYIzOeDQz7TALvtu6UG9oMo4vpzs9tX_EFShS8iB7j6jiSdiwkIr3ajwQzaBtQD_A")
+	tag, _ := Base64UrlDecode("XFBoMYUZodetZdvTiFvSkQ")
+	aad := []byte("eyJhbGciOiJSU0EtT0FFUCIsImVuYyI6IkEyNTZHQ00ifQ")
+
+	plaintext, err := decryptContent("A256GCM", cek, iv, ciphertext, tag, aad)
+	if err != nil {
+		t.Fatalf("decryption failed: %v", err)
+	}
+	if string(plaintext) != "The true sign of intelligence is not knowledge but imagination." {
+		t.Errorf("unexpected plaintext %q", plaintext)
+	}
+	if _, err := decryptContent("A256GCM", cek, iv, ciphertext, tag, []byte("eyJ9")); err == nil {
+		t.Errorf("modified additional authenticated data accepted")
+	}
+}
+
+func TestRsaOaepContentKey(t *testing.T) {
+	// OAEP is randomized, i.e. a round trip instead of a known answer
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cek := bytes.Repeat([]byte{0x42}, 32)
+	tests := []struct {
+		alg  string
+		hash hash.Hash
+	}{
+		{"RSA-OAEP", sha1.New()},
+		{"RSA-OAEP-256", sha256.New()},
+	}
+	for _, test := range tests {
+		t.Run(test.alg, func(t *testing.T) {
+			encryptedKey, err := rsa.EncryptOAEP(test.hash, rand.Reader, &privateKey.PublicKey, cek, nil)
+			if err != nil {
+				t.Fatalf("encryption failed: %v", err)
+			}
+			decrypted, err := decryptContentKey(JoseHeader{Alg: test.alg, Enc: "A256GCM"}, encryptedKey, privateKey)
+			if err != nil {
+				t.Fatalf("decryption failed: %v", err)
+			}
+			if !bytes.Equal(decrypted, cek) {
+				t.Errorf("unexpected content encryption key %X", decrypted)
+			}
+		})
+	}
+}
+
+func mustDecodeHex(t *testing.T, value string) []byte {
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatalf("invalid test vector %v: %v", value, err)
+	}
+	return decoded
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	h "o2token/helpers"
+)
+
+// JWT Secured Authorization Response Mode (JARM), i.e. the authorization response parameters
+// are delivered as claims of a signed (and optionally encrypted) JWT in the "response" parameter
+// 👉 https://openid.net/specs/oauth-v2-jarm.html
+
+func isJarmResponseMode(mode string) bool {
+	return mode == "jwt" || strings.HasSuffix(mode, ".jwt")
+}
+
+// The response parameters from a JARM response, after decryption (if applicable) and
+// verification of the signature, issuer, audience and expiration
+func jarmResponseParams(response string) (url.Values, error) {
+	if len(response) == 0 {
+		return nil, fmt.Errorf("missing 'response' parameter")
+	}
+	if strings.Count(response, ".") == 4 {
+		key, err := loadJarmDecryptionKey()
+		if err != nil {
+			return nil, err
+		}
+		plaintext, _, err := h.DecryptJwe(response, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt response: %v", err)
+		}
+		response = strings.TrimSpace(string(plaintext))
+	}
+
+	payload, err := verifyJarmSignature(response)
+	if err != nil {
+		return nil, err
+	}
+	var claims h.Unstruct
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("could not parse response claims: %v", err)
+	}
+	if err := checkJarmClaims(claims); err != nil {
+		return nil, err
+	}
+
+	// The remaining claims are the actual response parameters (e.g. "code" and "state")
+	params := url.Values{}
+	for name, value := range claims {
+		switch name {
+		case "aud", "exp":
+		default:
+			params.Set(name, fmt.Sprint(value))
+		}
+	}
+	return params, nil
+}
+
+// Signed by the AS (with a key from its JWKS) or with the client secret (HS algorithms)
+func verifyJarmSignature(response string) ([]byte, error) {
+	header, _, err := h.ParseJoseHeader(response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response JWT: %v", err)
+	}
+	if strings.HasPrefix(header.Alg, "HS") {
+		if len(appConfig.ClientSecret) == 0 {
+			return nil, fmt.Errorf("response signed with %v but no client secret configured", header.Alg)
+		}
+		payload, err := h.VerifyJws(response, []byte(appConfig.ClientSecret))
+		if err != nil {
+			return nil, fmt.Errorf("could not verify response signature: %v", err)
+		}
+		return payload, nil
+	}
+
+	if len(appConfig.JwksUri) == 0 {
+		return nil, fmt.Errorf("no JWKS URI configured (needed to verify the response signature)")
+	}
+	jwks, err := h.FetchJwks(appConfig.JwksUri)
+	if err != nil {
+		return nil, err
+	}
+	payload, key, err := h.VerifyJwsWithJwks(response, jwks)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify response signature: %v", err)
+	}
+	if appConfig.Verbose {
+		fmt.Printf("Verified response signature (%v) with key %q from %v\n", header.Alg, key.Kid, appConfig.JwksUri)
+	}
+	return payload, nil
+}
+
+func checkJarmClaims(claims h.Unstruct) error {
+	if len(appConfig.Issuer) == 0 {
+		return fmt.Errorf("no issuer configured (needed to check the response)")
+	}
+	if iss, _ := claims["iss"].(string); iss != appConfig.Issuer {
+		return fmt.Errorf("unexpected issuer of response (expected: %v, got: %v)", appConfig.Issuer, iss)
+	}
+	audiences := claimAudiences(claims)
+	intended := false
+	for _, audience := range audiences {
+		intended = intended || audience == appConfig.ClientID
+	}
+	if !intended {
+		return fmt.Errorf("response not intended for this client (aud: %v)", strings.Join(audiences, ", "))
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("missing expiration time of response")
+	}
+	if time.Now().After(time.Unix(int64(exp), 0)) {
+		return fmt.Errorf("response expired at %v", time.Unix(int64(exp), 0))
+	}
+	return nil
+}
+
+func loadJarmDecryptionKey() (interface{}, error) {
+	if len(appConfig.JarmDecryptionKey) == 0 {
+		return nil, fmt.Errorf("encrypted response but no decryption key configured")
+	}
+	data, err := os.ReadFile(appConfig.JarmDecryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not read decryption key: %v", err)
+	}
+	key, err := h.ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid decryption key: %v", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	h "o2token/helpers"
+)
+
+// Compare the header and the claims of two tokens, e.g. from staging and production or
+// before and after a change of the app registration
+
+const diffUsageMsg = `Usage: jwt diff [optional flags] <jwt-string|@file> <jwt-string|@file>`
+
+// Claims that differ between any two tokens
+var volatileClaims = []string{"exp", "iat", "jti", "nbf"}
+
+func diffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	allPtr := fs.Bool("all", false, fmt.Sprintf("compare all claims, also the volatile ones (%v)", strings.Join(volatileClaims, ", ")))
+	var ignored stringListFlag
+	fs.Var(&ignored, "ignore", "Additional claim to ignore (repeatable), e.g. \"uti\", \"address.street_address\" or 'events[\"http://...\"]'")
+	keyPtr := fs.String("key", "", "Decryption key for encrypted tokens (JWE); a PEM or JWK file")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("expected two tokens\n%v", diffUsageMsg)
+	}
+	headers, payloads := [2]interface{}{}, [2]interface{}{}
+	for i, input := range fs.Args() {
+		raw, err := h.ReadValueOrFile(input)
+		if err != nil {
+			return fmt.Errorf("could not read token %v: %v", i+1, err)
+		}
+		token, claims, err := decodeClaims(string(raw), *keyPtr)
+		if err != nil {
+			return fmt.Errorf("token %v: %v", i+1, err)
+		}
+		decodeJson(token.HeaderJson, &headers[i])
+		payloads[i] = claims
+	}
+
+	if !*allPtr {
+		ignored = append(ignored, volatileClaims...)
+	}
+	for _, ignoredPath := range ignored {
+		path, err := parsePath(ignoredPath)
+		if err != nil {
+			return err
+		}
+		for _, claims := range payloads {
+			removeClaim(claims, path)
+		}
+	}
+
+	for i, section := range []struct {
+		title  string
+		values [2]interface{}
+	}{{"Header:", headers}, {"Payload:", payloads}} {
+		if i > 0 {
+			fmt.Println()
+		}
+		lines := []string{}
+		compareValues("", section.values[0], section.values[1], &lines)
+		if len(lines) == 0 {
+			lines = append(lines, "No differences")
+		}
+		fmt.Printf("%v\n%v\n%v\n", section.title, strings.Repeat("-", len(section.title)), strings.Join(lines, "\n"))
+	}
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		fmt.Printf("\n(ignored: %v)\n", strings.Join(ignored, ", "))
+	}
+	return nil
+}
+
+// Added (+), removed (-) and changed (~) values by path; arrays are compared by their elements
+func compareValues(path string, a interface{}, b interface{}, lines *[]string) {
+	objectA, isObjectA := a.(map[string]interface{})
+	objectB, isObjectB := b.(map[string]interface{})
+	if isObjectA && isObjectB {
+		names := []string{}
+		for name := range objectA {
+			names = append(names, name)
+		}
+		for name := range objectB {
+			if _, found := objectA[name]; !found {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			childPath := h.MemberPath(path, name)
+			valueA, inA := objectA[name]
+			valueB, inB := objectB[name]
+			switch {
+			case !inB:
+				*lines = append(*lines, fmt.Sprintf("- %v: %v", childPath, compactJson(valueA)))
+			case !inA:
+				*lines = append(*lines, fmt.Sprintf("+ %v: %v", childPath, compactJson(valueB)))
+			default:
+				compareValues(childPath, valueA, valueB, lines)
+			}
+		}
+		return
+	}
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+
+	arrayA, isArrayA := a.([]interface{})
+	arrayB, isArrayB := b.([]interface{})
+	if isArrayA && isArrayB {
+		added, removed := missingElements(arrayB, arrayA), missingElements(arrayA, arrayB)
+		changes := []string{}
+		if len(added) > 0 {
+			changes = append(changes, "added "+strings.Join(added, ", "))
+		}
+		if len(removed) > 0 {
+			changes = append(changes, "removed "+strings.Join(removed, ", "))
+		}
+		if len(changes) == 0 {
+			changes = append(changes, "same elements in a different order")
+		}
+		*lines = append(*lines, fmt.Sprintf("~ %v: %v", path, strings.Join(changes, "; ")))
+		return
+	}
+	*lines = append(*lines, fmt.Sprintf("~ %v: %v → %v", path, compactJson(a), compactJson(b)))
+}
+
+// The elements of the first array that are not in the second one
+func missingElements(elements []interface{}, others []interface{}) []string {
+	missing := []string{}
+	for _, element := range elements {
+		found := false
+		for _, other := range others {
+			found = found || reflect.DeepEqual(element, other)
+		}
+		if !found {
+			missing = append(missing, compactJson(element))
+		}
+	}
+	return missing
+}
+
+// Remove a claim given by its path (see parsePath), e.g. "exp" or "address.street_address"
+func removeClaim(value interface{}, path []interface{}) {
+	if len(path) == 0 {
+		return
+	}
+	parent, found := lookupPath(value, path[:len(path)-1])
+	if !found {
+		return
+	}
+	object, isObject := parent.(map[string]interface{})
+	name, isName := path[len(path)-1].(string)
+	if isObject && isName {
+		delete(object, name)
+	}
+}
+
+func compactJson(value interface{}) string {
+	compact, _ := json.Marshal(value)
+	return string(compact)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -12,19 +13,144 @@ import (
 )
 
 const usageMsg = `Usage: jwt [optional flags] <jwt-string>
-   or: echo <jwt-string> | jwt [optional flags]`
+   or: echo <jwt-string> | jwt [optional flags]
+   or: jwt <command> [optional flags] <jwt-string> (commands: assert, diff, get, sign, verify)`
+
+// Commands are selected via the first CLI argument and handle their own flags
+// (without a command, the JWT is decoded and shown)
+var subcommands = map[string]func(args []string) error{
+	"assert": assertCommand,
+	"diff":   diffCommand,
+	"get":    getCommand,
+	"sign":   signCommand,
+	"verify": verifyCommand,
+}
+
+// Signature and encryption algorithms (RFC 7518 and RFC 8037)
+var algorithmDescriptions = map[string]string{
+	"HS256": "HMAC using SHA-256",
+	"HS384": "HMAC using SHA-384",
+	"HS512": "HMAC using SHA-512",
+	"RS256": "RSASSA-PKCS1-v1_5 using SHA-256",
+	"RS384": "RSASSA-PKCS1-v1_5 using SHA-384",
+	"RS512": "RSASSA-PKCS1-v1_5 using SHA-512",
+	"PS256": "RSASSA-PSS using SHA-256",
+	"PS384": "RSASSA-PSS using SHA-384",
+	"PS512": "RSASSA-PSS using SHA-512",
+	"ES256": "ECDSA using P-256 and SHA-256",
+	"ES384": "ECDSA using P-384 and SHA-384",
+	"ES512": "ECDSA using P-521 and SHA-512",
+	"EdDSA": "Edwards-curve signature (e.g. Ed25519)",
+	"none":  "unsecured JWT without signature",
+
+	"RSA-OAEP":       "RSAES OAEP using SHA-1",
+	"RSA-OAEP-256":   "RSAES OAEP using SHA-256",
+	"A128KW":         "AES key wrap using a 128-bit key",
+	"A192KW":         "AES key wrap using a 192-bit key",
+	"A256KW":         "AES key wrap using a 256-bit key",
+	"dir":            "direct use of a shared symmetric key",
+	"ECDH-ES":        "ECDH ephemeral-static key agreement",
+	"ECDH-ES+A128KW": "ECDH-ES and AES key wrap using a 128-bit key",
+	"ECDH-ES+A192KW": "ECDH-ES and AES key wrap using a 192-bit key",
+	"ECDH-ES+A256KW": "ECDH-ES and AES key wrap using a 256-bit key",
+	"A128GCM":        "AES GCM using a 128-bit key",
+	"A192GCM":        "AES GCM using a 192-bit key",
+	"A256GCM":        "AES GCM using a 256-bit key",
+	"A128CBC-HS256":  "AES-128-CBC and HMAC using SHA-256",
+	"A192CBC-HS384":  "AES-192-CBC and HMAC using SHA-384",
+	"A256CBC-HS512":  "AES-256-CBC and HMAC using SHA-512",
+}
 
 func main() {
-	pureOutputPtr := flag.Bool("pure", false, "show decoded JWT body without any re-formatting or annotations (default false)")
+	if len(os.Args) > 1 {
+		if command, found := subcommands[os.Args[1]]; found {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	pureOutputPtr := flag.Bool("pure", false, "show the decoded payload (or the section selected by --header/--payload) without any re-formatting or annotations (default false)")
+	headerPtr := flag.Bool("header", false, "show the decoded JOSE header (only)")
+	payloadPtr := flag.Bool("payload", false, "show the decoded payload (only)")
+	caBundlePtr := flag.String("ca-bundle", "", "PEM file with trusted CA certificates to validate the \"x5c\" certificate chain with")
+	var dictionaryFiles stringListFlag
+	flag.Var(&dictionaryFiles, "claim-dictionary", "JSON file with explanations of claims, e.g. {\"roles\": {\"\": \"app roles\", \"Admin\": \"administrator\"}} (repeatable)")
+	keyPtr := flag.String("key", "", "decryption key for encrypted tokens (JWE); a PEM or JWK file (JWK of type \"oct\" for symmetric keys)")
 
 	flag.Parse()
+	options := displayOptions{pure: *pureOutputPtr, caBundle: *caBundlePtr}
+	for _, file := range dictionaryFiles {
+		dictionary, err := loadClaimDictionary(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		options.dictionaries = append(options.dictionaries, dictionary)
+	}
+
+	jwtStr, sd, err := splitSdJwt(readJwtInput(flag.Args()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: not a valid SD-JWT: %v\n", err)
+		os.Exit(1)
+	}
+	token, err := decodeToken(jwtStr, *keyPtr)
+	if err != nil && len(token.Encryption) > 0 {
+		// What is known about the encryption may tell which key is needed
+		fmt.Println(formatSection("encryption", token, nil, options))
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: not a valid JWT: %v\n", err)
+		os.Exit(1)
+	}
+	if sd != nil {
+		if err := sd.resolve(token.PayloadJson); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: not a valid SD-JWT: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	var jwtStr string
+	// A single selected section is shown as is (e.g. for piping into jq), otherwise with titles
+	selected := []string{}
+	if *headerPtr {
+		selected = append(selected, "header")
+	}
+	if *payloadPtr || (*pureOutputPtr && len(selected) == 0) {
+		selected = append(selected, "payload") // --pure alone shows the payload (e.g. for piping into jq)
+	}
+	if len(selected) == 1 {
+		fmt.Println(formatSection(selected[0], token, sd, options))
+		return
+	}
+	if len(selected) == 0 {
+		selected = []string{"header", "payload", "signature"}
+		if len(token.Encryption) > 0 {
+			selected = append([]string{"encryption"}, selected...)
+		}
+		if len(token.Header.X5c) > 0 {
+			selected = append(selected, "certificates")
+		}
+		if sd != nil {
+			selected = append(selected, "disclosures", "key binding")
+		}
+	}
+	for i, section := range selected {
+		if i > 0 {
+			fmt.Println()
+		}
+		title := strings.ToUpper(section[:1]) + section[1:] + ":"
+		fmt.Printf("%v\n%v\n%v\n", title, strings.Repeat("-", len(title)), formatSection(section, token, sd, options))
+	}
+}
 
+func readJwtInput(args []string) string {
 	stat, _ := os.Stdin.Stat()
 	isPipe := (stat.Mode() & os.ModeCharDevice) == 0
 
-	if isPipe && flag.NArg() > 0 {
+	if isPipe && len(args) > 0 {
 		fmt.Fprintf(os.Stderr, "Error: Cannot accept both piped input and command line argument\n%s\n", usageMsg)
 		os.Exit(1)
 	}
@@ -36,18 +162,155 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Error reading from stdin: %v\n", err)
 			os.Exit(1)
 		}
-		jwtStr = strings.TrimSpace(string(input))
-	} else if flag.NArg() == 1 {
-		jwtStr = flag.Arg(0)
-	} else {
-		fmt.Fprintln(os.Stderr, usageMsg)
-		os.Exit(1)
+		return strings.TrimSpace(string(input))
+	} else if len(args) == 1 {
+		return args[0]
 	}
+	fmt.Fprintln(os.Stderr, usageMsg)
+	os.Exit(1)
+	return ""
+}
 
-	if *pureOutputPtr {
-		fmt.Println(h.JwtToString(jwtStr))
+// Encrypted tokens (JWE) are decrypted with the given key (a nested JWT is decoded as well),
+// without a key only their header can be decoded
+func decodeToken(jwtStr string, keyFile string) (h.JwtSections, error) {
+	if len(keyFile) == 0 {
+		if header, parts, err := h.ParseJoseHeader(jwtStr); err == nil && len(parts) == 5 {
+			headerJson, _ := h.Base64UrlDecode(parts[0])
+			token := h.JwtSections{Encryption: []h.JweLayer{{Header: header, HeaderJson: headerJson}}}
+			return token, fmt.Errorf("the token is encrypted (JWE); a decryption key is needed (--key)")
+		}
+		return h.DecodeJwt(jwtStr)
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return h.JwtSections{}, fmt.Errorf("could not read key: %v", err)
+	}
+	key, err := h.ParseKey(data)
+	if err != nil {
+		return h.JwtSections{}, fmt.Errorf("invalid key: %v", err)
+	}
+	return h.DecodeNestedJwt(jwtStr, key)
+}
+
+// The header and the claims of a token; the claims of an SD-JWT with all disclosed claims
+func decodeClaims(input string, keyFile string) (h.JwtSections, map[string]interface{}, error) {
+	jwtStr, sd, err := splitSdJwt(strings.TrimSpace(input))
+	if err != nil {
+		return h.JwtSections{}, nil, fmt.Errorf("not a valid SD-JWT: %v", err)
+	}
+	token, err := decodeToken(jwtStr, keyFile)
+	if err != nil {
+		return token, nil, err
+	}
+	if sd != nil {
+		if err := sd.resolve(token.PayloadJson); err != nil {
+			return token, nil, fmt.Errorf("not a valid SD-JWT: %v", err)
+		}
+		return token, sd.claims, nil
+	}
+	var claims map[string]interface{}
+	if err := decodeJson(token.PayloadJson, &claims); err != nil {
+		return token, nil, fmt.Errorf("payload is not a JSON object: %v", err)
+	}
+	return token, claims, nil
+}
+
+type displayOptions struct {
+	pure         bool
+	caBundle     string
+	dictionaries []h.ClaimDictionary
+}
+
+func loadClaimDictionary(file string) (h.ClaimDictionary, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read claim dictionary: %v", err)
+	}
+	var dictionary h.ClaimDictionary
+	if err := json.Unmarshal(data, &dictionary); err != nil {
+		return nil, fmt.Errorf("invalid claim dictionary %v (expected a JSON object of claim names with objects of values and explanations): %v", file, err)
+	}
+	return dictionary, nil
+}
+
+// The payload of an SD-JWT is shown with all disclosed claims (unless pure)
+func formatSection(section string, token h.JwtSections, sd *sdJwt, options displayOptions) string {
+	pure := options.pure
+	switch section {
+	case "encryption":
+		return describeEncryption(token, pure)
+	case "header":
+		if pure {
+			return string(token.HeaderJson)
+		}
+		return h.PrettyJson(string(token.HeaderJson))
+	case "payload":
+		if pure {
+			return string(token.PayloadJson)
+		}
+		payloadJson := token.PayloadJson
+		annotators := h.ClaimAnnotators(token.PayloadJson, options.dictionaries...)
+		if sd != nil {
+			payloadJson, _ = json.Marshal(sd.claims)
+			annotators = append(annotators, sd.annotator())
+		}
+		return h.AnnotateJson(string(payloadJson), annotators...)
+	case "disclosures":
+		return describeDisclosures(sd)
+	case "key binding":
+		return describeKeyBinding(sd)
+	case "certificates":
+		return describeCertificates(token.Header, options.caBundle)
+	}
+	return describeSignature(token)
+}
+
+// The JWE header(s) with the algorithms of each encryption layer
+func describeEncryption(token h.JwtSections, pure bool) string {
+	layers := []string{}
+	for _, layer := range token.Encryption {
+		if pure {
+			layers = append(layers, string(layer.HeaderJson))
+			continue
+		}
+		lines := []string{
+			h.PrettyJson(string(layer.HeaderJson)),
+			fmt.Sprintf("Key management: %v (%v)", layer.Header.Alg, describeAlgorithm(layer.Header.Alg)),
+			fmt.Sprintf("Content encryption: %v (%v)", layer.Header.Enc, describeAlgorithm(layer.Header.Enc)),
+		}
+		if len(layer.Header.Kid) > 0 {
+			lines = append(lines, fmt.Sprintf("Key ID: %v", layer.Header.Kid))
+		}
+		layers = append(layers, strings.Join(lines, "\n"))
+	}
+	return strings.Join(layers, "\n\n")
+}
+
+func describeAlgorithm(alg string) string {
+	if description, known := algorithmDescriptions[alg]; known {
+		return description
+	}
+	return "unknown algorithm"
+}
+
+// Signature metadata (the signature itself is not verified)
+func describeSignature(token h.JwtSections) string {
+	if len(token.Encryption) > 0 && len(token.SigningInput) == 0 {
+		return "Not signed (the encrypted content is the payload itself, not a nested JWT)"
+	}
+	alg := token.Header.Alg
+	lines := []string{fmt.Sprintf("Algorithm: %v (%v)", alg, describeAlgorithm(alg))}
+	if len(token.Header.Kid) > 0 {
+		lines = append(lines, fmt.Sprintf("Key ID: %v", token.Header.Kid))
+	}
+	if len(token.Signature) == 0 {
+		lines = append(lines, "Signature: <empty>")
 	} else {
-		epochKeys := []string{"iat", "nbf", "exp", "xms_tcdt"} // xms_tcdt is probably azure proprietary
-		fmt.Println(h.InjectEpochFieldComments(h.PrettyJson(h.JwtToString(jwtStr)), epochKeys))
+		lines = append(lines, fmt.Sprintf("Signature: %v bytes (not verified)", len(token.Signature)))
+	}
+	if alg == "none" && len(token.Signature) > 0 {
+		lines = append(lines, "WARNING: signature present although the algorithm is \"none\"")
 	}
+	return strings.Join(lines, "\n")
 }
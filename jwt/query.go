@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Claim extraction and assertions for scripts, e.g. smoke tests of tokens in CI pipelines
+
+const getUsageMsg = `Usage: jwt get [optional flags] <path> <jwt-string>
+   or: echo <jwt-string> | jwt get [optional flags] <path>
+       (path e.g. .aud, .roles[0], .cnf.jkt or .events["http://schemas.openid.net/event/backchannel-logout"])`
+
+const assertUsageMsg = `Usage: jwt assert [optional flags] <expression>... <jwt-string>
+   or: echo <jwt-string> | jwt assert [optional flags] <expression>...
+       (expression: <path> <operator> <value>, e.g. "aud contains api://x", "exp > now+5m" or "scp has User.Read";
+        operators: == != < <= > >= contains has matches, or unary: exists missing)`
+
+func getCommand(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	headerPtr := fs.Bool("header", false, "get the value from the JOSE header (instead of the payload)")
+	keyPtr := fs.String("key", "", "Decryption key for encrypted tokens (JWE); a PEM or JWK file")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return fmt.Errorf("missing path\n%v", getUsageMsg)
+	}
+	path, err := parsePath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	document, err := decodeDocument(readJwtInput(fs.Args()[1:]), *keyPtr, *headerPtr)
+	if err != nil {
+		return err
+	}
+	value, found := lookupPath(document, path)
+	if !found {
+		return fmt.Errorf("%v not found", fs.Arg(0))
+	}
+	// Strings as they are (e.g. for shell variables), everything else as JSON
+	if str, ok := value.(string); ok {
+		fmt.Println(str)
+	} else {
+		fmt.Println(compactJson(value))
+	}
+	return nil
+}
+
+func assertCommand(args []string) error {
+	fs := flag.NewFlagSet("assert", flag.ExitOnError)
+	headerPtr := fs.Bool("header", false, "evaluate the expressions against the JOSE header (instead of the payload)")
+	keyPtr := fs.String("key", "", "Decryption key for encrypted tokens (JWE); a PEM or JWK file")
+	quietPtr := fs.Bool("quiet", false, "don't show the results, only set the exit code")
+	fs.Parse(args)
+
+	// The token is the last argument unless piped (expressions always contain blanks)
+	expressions := fs.Args()
+	tokenArgs := []string{}
+	if last := len(expressions) - 1; last >= 0 && len(strings.Fields(expressions[last])) == 1 {
+		expressions, tokenArgs = expressions[:last], expressions[last:]
+	}
+	if len(expressions) == 0 {
+		return fmt.Errorf("missing expression\n%v", assertUsageMsg)
+	}
+	assertions := []assertion{}
+	for _, expression := range expressions {
+		assertion, err := parseAssertion(expression)
+		if err != nil {
+			return fmt.Errorf("invalid expression %q: %v\n%v", expression, err, assertUsageMsg)
+		}
+		assertions = append(assertions, assertion)
+	}
+	document, err := decodeDocument(readJwtInput(tokenArgs), *keyPtr, *headerPtr)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	now := time.Now()
+	for _, assertion := range assertions {
+		ok, actual := assertion.evaluate(document, now)
+		if !ok {
+			failed++
+		}
+		if *quietPtr {
+			continue
+		}
+		if ok {
+			fmt.Printf("✔ %v\n", assertion.expression)
+		} else {
+			fmt.Printf("✘ %v (actual: %v)\n", assertion.expression, actual)
+		}
+	}
+	if failed > 0 {
+		if *quietPtr {
+			os.Exit(1)
+		}
+		return fmt.Errorf("%v of %v assertions failed", failed, len(assertions))
+	}
+	return nil
+}
+
+// The claims (or the header) of a token as generic JSON
+func decodeDocument(input string, keyFile string, header bool) (interface{}, error) {
+	token, claims, err := decodeClaims(input, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if !header {
+		return claims, nil
+	}
+	var document interface{}
+	decodeJson(token.HeaderJson, &document)
+	return document, nil
+}
+
+// A path like .roles[0] or .events["<name>"] as member names (string) and array indices (int)
+func parsePath(path string) ([]interface{}, error) {
+	pattern := regexp.MustCompile(`^(?:\.?([^.\[\]"]+)|\.?"((?:[^"\\]|\\.)*)"|\[(-?\d+)\]|\["((?:[^"\\]|\\.)*)"\])`)
+	segments := []interface{}{}
+	rest := strings.TrimSpace(path)
+	if rest == "." {
+		return segments, nil
+	}
+	for len(rest) > 0 {
+		match := pattern.FindStringSubmatch(rest)
+		if match == nil {
+			return nil, fmt.Errorf("invalid path %q (at %q)", path, rest)
+		}
+		switch {
+		case len(match[1]) > 0:
+			segments = append(segments, match[1])
+		case len(match[3]) > 0:
+			index, _ := strconv.Atoi(match[3])
+			segments = append(segments, index)
+		default:
+			quoted, err := strconv.Unquote(`"` + match[2] + match[4] + `"`)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: %v", path, err)
+			}
+			segments = append(segments, quoted)
+		}
+		rest = rest[len(match[0]):]
+	}
+	return segments, nil
+}
+
+// Negative indices count from the end (e.g. [-1] is the last element)
+func lookupPath(value interface{}, path []interface{}) (interface{}, bool) {
+	for _, segment := range path {
+		switch segment := segment.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[segment]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := value.([]interface{})
+			if segment < 0 {
+				segment += len(array)
+			}
+			if !ok || segment < 0 || segment >= len(array) {
+				return nil, false
+			}
+			value = array[segment]
+		}
+	}
+	return value, true
+}
+
+type assertion struct {
+	expression string
+	path       []interface{}
+	operator   string
+	operand    string
+}
+
+var unaryOperators = map[string]bool{"exists": true, "missing": true}
+var binaryOperators = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "contains": true, "has": true, "matches": true}
+
+func parseAssertion(expression string) (assertion, error) {
+	fields := strings.Fields(expression)
+	if len(fields) < 2 {
+		return assertion{}, fmt.Errorf("expected <path> <operator> [<value>]")
+	}
+	path, err := parsePath(fields[0])
+	if err != nil {
+		return assertion{}, err
+	}
+	parsed := assertion{expression: expression, path: path, operator: fields[1]}
+	switch {
+	case unaryOperators[parsed.operator] && len(fields) == 2:
+	case binaryOperators[parsed.operator] && len(fields) > 2:
+		// The value is the rest of the expression (and may contain blanks)
+		rest := strings.TrimSpace(strings.TrimSpace(expression)[len(fields[0]):])
+		parsed.operand = strings.TrimSpace(rest[len(fields[1]):])
+	default:
+		return assertion{}, fmt.Errorf("unsupported operator %q or missing value", parsed.operator)
+	}
+	if parsed.operator == "matches" {
+		if _, err := regexp.Compile(parsed.operand); err != nil {
+			return assertion{}, fmt.Errorf("invalid regular expression: %v", err)
+		}
+	}
+	return parsed, nil
+}
+
+// Whether the assertion holds and the actual value (for failure messages)
+func (a assertion) evaluate(document interface{}, now time.Time) (bool, string) {
+	value, found := lookupPath(document, a.path)
+	if !found {
+		return a.operator == "missing", "<missing>"
+	}
+	actual := compactJson(value)
+	switch a.operator {
+	case "exists":
+		return true, actual
+	case "missing":
+		return false, actual
+	case "==":
+		return equalsOperand(value, a.operand), actual
+	case "!=":
+		return !equalsOperand(value, a.operand), actual
+	case "contains":
+		// An element of an array, or a single value (e.g. "aud" with only one audience)
+		if elements, ok := value.([]interface{}); ok {
+			return containsOperand(elements, a.operand), actual
+		}
+		return equalsOperand(value, a.operand), actual
+	case "has":
+		// An element of an array or a word of a space-separated string (e.g. "scp")
+		if str, ok := value.(string); ok {
+			for _, word := range strings.Fields(str) {
+				if word == a.operand {
+					return true, actual
+				}
+			}
+			return false, actual
+		}
+		elements, _ := value.([]interface{})
+		return containsOperand(elements, a.operand), actual
+	case "matches":
+		str, ok := value.(string)
+		if !ok {
+			str = actual
+		}
+		return regexp.MustCompile(a.operand).MatchString(str), actual
+	}
+
+	// Numeric comparison; values can be times (e.g. now+5m) compared with epoch claims
+	number, err := toNumber(value)
+	if err != nil {
+		return false, actual
+	}
+	operand, err := operandNumber(a.operand, now)
+	if err != nil {
+		return false, fmt.Sprintf("%v (%v)", actual, err)
+	}
+	if timeClaimValue(a.path) {
+		actual = fmt.Sprintf("%v (%v)", actual, time.Unix(int64(number), 0))
+	}
+	switch a.operator {
+	case "<":
+		return number < operand, actual
+	case "<=":
+		return number <= operand, actual
+	case ">":
+		return number > operand, actual
+	}
+	return number >= operand, actual
+}
+
+func equalsOperand(value interface{}, operand string) bool {
+	switch value := value.(type) {
+	case string:
+		return value == operand
+	case json.Number:
+		number, err := value.Float64()
+		other, otherErr := strconv.ParseFloat(operand, 64)
+		if err == nil && otherErr == nil {
+			return number == other
+		}
+		return value.String() == operand
+	}
+	return compactJson(value) == operand
+}
+
+func containsOperand(elements []interface{}, operand string) bool {
+	for _, element := range elements {
+		if equalsOperand(element, operand) {
+			return true
+		}
+	}
+	return false
+}
+
+func toNumber(value interface{}) (float64, error) {
+	switch value := value.(type) {
+	case json.Number:
+		return value.Float64()
+	case string:
+		return strconv.ParseFloat(value, 64)
+	}
+	return 0, fmt.Errorf("not a number")
+}
+
+// A number or a time (epoch seconds), e.g. now, now+5m, -1h or an RFC 3339 timestamp
+func operandNumber(operand string, now time.Time) (float64, error) {
+	if number, err := strconv.ParseFloat(operand, 64); err == nil {
+		return number, nil
+	}
+	timestamp, err := parseTimeSpec(operand, now)
+	if err != nil {
+		return 0, err
+	}
+	return float64(timestamp.Unix()), nil
+}
+
+func timeClaimValue(path []interface{}) bool {
+	if len(path) == 0 {
+		return false
+	}
+	name, _ := path[len(path)-1].(string)
+	switch name {
+	case "exp", "nbf", "iat", "auth_time":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	h "o2token/helpers"
+)
+
+// Selective Disclosure JWT (SD-JWT), i.e. an issuer-signed JWT followed by the disclosures of
+// hidden claims and optionally a key binding JWT: <jwt>~<disclosure>~...~<kb-jwt>
+// 👉 https://datatracker.ietf.org/doc/html/draft-ietf-oauth-selective-disclosure-jwt
+
+type disclosure struct {
+	encoded string
+	digest  string
+	name    string // empty for array elements
+	value   interface{}
+	path    string // of the disclosed claim (see h.MemberPath), empty if not referenced by a digest
+}
+
+type sdJwt struct {
+	disclosures     []*disclosure
+	keyBinding      *h.JwtSections
+	keyBindingInput string // issuer-signed JWT and disclosures, covered by the "sd_hash" of the key binding JWT
+	hash            crypto.Hash
+	claims          map[string]interface{} // payload with all disclosed claims
+	problems        []string
+}
+
+var sdHashAlgorithms = map[string]crypto.Hash{
+	"sha-256": crypto.SHA256,
+	"sha-384": crypto.SHA384,
+	"sha-512": crypto.SHA512,
+}
+
+// Split an SD-JWT into the issuer-signed JWT and the SD-JWT parts (nil for a plain JWT)
+func splitSdJwt(input string) (string, *sdJwt, error) {
+	if !strings.Contains(input, "~") {
+		return input, nil, nil
+	}
+	parts := strings.Split(input, "~")
+	last := len(parts) - 1
+	sd := &sdJwt{keyBindingInput: strings.Join(parts[:last], "~") + "~"}
+	for i, encoded := range parts[1:last] {
+		disclosure, err := parseDisclosure(encoded)
+		if err != nil {
+			return parts[0], nil, fmt.Errorf("malformed disclosure %v: %v", i+1, err)
+		}
+		sd.disclosures = append(sd.disclosures, disclosure)
+	}
+	if len(parts[last]) > 0 {
+		keyBinding, err := h.DecodeJwt(parts[last])
+		if err != nil {
+			return parts[0], nil, fmt.Errorf("malformed key binding JWT: %v", err)
+		}
+		sd.keyBinding = &keyBinding
+	}
+	return parts[0], sd, nil
+}
+
+// A disclosure is a JSON array of salt, claim name and value (or salt and value for array elements)
+func parseDisclosure(encoded string) (*disclosure, error) {
+	raw, err := h.Base64UrlDecode(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var elements []interface{}
+	if err := decodeJson(raw, &elements); err != nil {
+		return nil, fmt.Errorf("not a JSON array: %v", err)
+	}
+	switch len(elements) {
+	case 2:
+		return &disclosure{encoded: encoded, value: elements[1]}, nil
+	case 3:
+		name, ok := elements[1].(string)
+		if !ok || name == "_sd" || name == "..." {
+			return nil, fmt.Errorf("invalid claim name %v", elements[1])
+		}
+		return &disclosure{encoded: encoded, name: name, value: elements[2]}, nil
+	}
+	return nil, fmt.Errorf("expected 2 or 3 elements, got %v", len(elements))
+}
+
+// Replace the digests in the payload ("_sd" arrays and {"...": <digest>} array elements)
+// with the disclosed claims; digests without disclosure (e.g. decoys) are dropped
+func (sd *sdJwt) resolve(payloadJson []byte) error {
+	var claims map[string]interface{}
+	if err := decodeJson(payloadJson, &claims); err != nil {
+		return fmt.Errorf("payload is not a JSON object: %v", err)
+	}
+	hashName, _ := claims["_sd_alg"].(string)
+	if len(hashName) == 0 {
+		hashName = "sha-256"
+	}
+	hash, supported := sdHashAlgorithms[hashName]
+	if !supported {
+		return fmt.Errorf("unsupported disclosure digest algorithm %q", hashName)
+	}
+	sd.hash = hash
+	delete(claims, "_sd_alg")
+
+	byDigest := map[string]*disclosure{}
+	for _, disclosure := range sd.disclosures {
+		disclosure.digest = sd.digest(disclosure.encoded)
+		byDigest[disclosure.digest] = disclosure
+	}
+	sd.claims = sd.disclose(claims, "", byDigest).(map[string]interface{})
+	for i, disclosure := range sd.disclosures {
+		if len(disclosure.path) == 0 {
+			sd.problems = append(sd.problems, fmt.Sprintf("disclosure %v is not referenced by any digest in the payload", i+1))
+		}
+	}
+	return nil
+}
+
+func (sd *sdJwt) disclose(value interface{}, path string, byDigest map[string]*disclosure) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for name, child := range value {
+			if name != "_sd" {
+				result[name] = sd.disclose(child, h.MemberPath(path, name), byDigest)
+			}
+		}
+		digests, _ := value["_sd"].([]interface{})
+		for _, digest := range digests {
+			disclosure := sd.lookup(digest, path, byDigest)
+			if disclosure == nil {
+				continue
+			}
+			if len(disclosure.name) == 0 {
+				sd.problems = append(sd.problems, fmt.Sprintf("array element disclosed as claim of %v", displayPath(path)))
+				continue
+			}
+			if _, exists := result[disclosure.name]; exists {
+				sd.problems = append(sd.problems, fmt.Sprintf("disclosed claim %v already exists", displayPath(h.MemberPath(path, disclosure.name))))
+				continue
+			}
+			disclosure.path = h.MemberPath(path, disclosure.name)
+			result[disclosure.name] = sd.disclose(disclosure.value, disclosure.path, byDigest)
+		}
+		return result
+	case []interface{}:
+		result := []interface{}{}
+		for _, element := range value {
+			elementPath := fmt.Sprintf("%v[%v]", path, len(result))
+			if object, ok := element.(map[string]interface{}); ok && len(object) == 1 && object["..."] != nil {
+				disclosure := sd.lookup(object["..."], path, byDigest)
+				if disclosure == nil {
+					continue
+				}
+				if len(disclosure.name) > 0 {
+					sd.problems = append(sd.problems, fmt.Sprintf("claim %q disclosed as array element of %v", disclosure.name, displayPath(path)))
+					continue
+				}
+				disclosure.path = elementPath
+				element = disclosure.value
+			}
+			result = append(result, sd.disclose(element, elementPath, byDigest))
+		}
+		return result
+	}
+	return value
+}
+
+func (sd *sdJwt) lookup(digest interface{}, path string, byDigest map[string]*disclosure) *disclosure {
+	digestStr, _ := digest.(string)
+	disclosure, found := byDigest[digestStr]
+	if !found {
+		return nil
+	}
+	if len(disclosure.path) > 0 {
+		sd.problems = append(sd.problems, fmt.Sprintf("digest of disclosure %v is used more than once (in %v)", displayPath(disclosure.path), displayPath(path)))
+		return nil
+	}
+	return disclosure
+}
+
+func (sd *sdJwt) digest(input string) string {
+	digest := sd.hash.New()
+	digest.Write([]byte(input))
+	return base64.RawURLEncoding.EncodeToString(digest.Sum(nil))
+}
+
+// Marks the disclosed claims in the payload
+func (sd *sdJwt) annotator() h.Annotator {
+	return func(path string, value interface{}) string {
+		for _, disclosure := range sd.disclosures {
+			if len(disclosure.path) > 0 && disclosure.path == path {
+				return "selectively disclosed"
+			}
+		}
+		return ""
+	}
+}
+
+// The disclosed claims by path (e.g. ".address.street_address" or ".nationalities[1]")
+func describeDisclosures(sd *sdJwt) string {
+	disclosures := append([]*disclosure{}, sd.disclosures...)
+	sort.SliceStable(disclosures, func(i, j int) bool { return disclosures[i].path < disclosures[j].path })
+	lines := []string{}
+	for _, disclosure := range disclosures {
+		path := displayPath(disclosure.path)
+		if len(disclosure.path) == 0 {
+			path = "<not referenced>"
+			if len(disclosure.name) > 0 {
+				path += " " + disclosure.name
+			}
+		}
+		value, _ := json.Marshal(disclosure.value)
+		lines = append(lines, fmt.Sprintf("%v: %v", path, string(value)))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "None (all selectively disclosable claims are hidden)")
+	}
+	for _, problem := range sd.problems {
+		lines = append(lines, "WARNING: "+problem)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// The key binding JWT proves possession of the key in the "cnf" claim and covers the
+// issuer-signed JWT and the disclosures with its "sd_hash"
+func describeKeyBinding(sd *sdJwt) string {
+	if sd.keyBinding == nil {
+		return "None (no key binding JWT)"
+	}
+	keyBinding := sd.keyBinding
+	lines := []string{
+		h.PrettyJson(string(keyBinding.HeaderJson)),
+		h.AnnotateJson(string(keyBinding.PayloadJson), h.TimeAnnotator(time.Now())),
+	}
+	if keyBinding.Header.Typ != "kb+jwt" {
+		lines = append(lines, fmt.Sprintf("WARNING: unexpected type %q (expected: kb+jwt)", keyBinding.Header.Typ))
+	}
+
+	var claims map[string]interface{}
+	json.Unmarshal(keyBinding.PayloadJson, &claims)
+	if sdHash, _ := claims["sd_hash"].(string); sd.hash == 0 {
+		lines = append(lines, "SD hash: not checked")
+	} else if sdHash == sd.digest(sd.keyBindingInput) {
+		lines = append(lines, "SD hash: matches the SD-JWT")
+	} else {
+		lines = append(lines, "WARNING: \"sd_hash\" doesn't match the SD-JWT (issuer-signed JWT and disclosures)")
+	}
+
+	confirmation, _ := sd.claims["cnf"].(map[string]interface{})
+	jwkJson, _ := json.Marshal(confirmation["jwk"])
+	var jwk h.Jwk
+	if confirmation["jwk"] == nil || json.Unmarshal(jwkJson, &jwk) != nil {
+		lines = append(lines, "Signature: not verified (no \"cnf\" key in the payload)")
+	} else if key, err := jwk.Key(false); err != nil {
+		lines = append(lines, fmt.Sprintf("WARNING: invalid \"cnf\" key: %v", err))
+	} else if _, err := h.VerifyJws(keyBinding.SigningInput+"."+base64.RawURLEncoding.EncodeToString(keyBinding.Signature), key); err != nil {
+		lines = append(lines, fmt.Sprintf("WARNING: signature not verified with the \"cnf\" key: %v", err))
+	} else {
+		lines = append(lines, fmt.Sprintf("Signature: verified with the \"cnf\" key (%v)", keyBinding.Header.Alg))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Keep numbers as they are (e.g. large timestamps)
+func decodeJson(data []byte, target interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	return decoder.Decode(target)
+}
+
+// In the syntax of "jwt get", e.g. ".address.street_address" or `["http://example.com/claim"]`
+func displayPath(path string) string {
+	switch {
+	case len(path) == 0:
+		return "the payload"
+	case strings.HasPrefix(path, "["):
+		return path
+	}
+	return "." + path
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	h "o2token/helpers"
+)
+
+// Create (and sign) a JWT from arbitrary claims, e.g. test tokens for resource servers
+
+const signUsageMsg = `Usage: jwt sign --key <pem-file|jwk-file> [optional flags] <claims-json|@file>
+   or: echo <claims-json> | jwt sign --secret <hmac-secret> [optional flags]`
+
+func signCommand(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	keyPtr := fs.String("key", "", "Private signing key; a PEM or JWK file")
+	secretPtr := fs.String("secret", "", "HMAC secret (for HS256/HS384/HS512)")
+	algPtr := fs.String("alg", "", "Signature algorithm (default <derived from the key>, e.g. RS256 or HS256)")
+	headerPtr := fs.String("header", "", "Additional/overridden header fields as JSON object, inline or @<file>")
+	expPtr := fs.String("exp", "", "Expiration time; now, +<duration> (e.g. +1h), now-<duration>, epoch seconds or RFC 3339")
+	iatPtr := fs.String("iat", "", "Issued at time (same formats as --exp)")
+	nbfPtr := fs.String("nbf", "", "Not before time (same formats as --exp)")
+	fs.Parse(args)
+
+	if (len(*keyPtr) == 0) == (len(*secretPtr) == 0) {
+		return fmt.Errorf("expected either --key or --secret\n%v", signUsageMsg)
+	}
+	claims, err := readClaimsInput(fs.Args())
+	if err != nil {
+		return err
+	}
+	header := h.Unstruct{"typ": "JWT"}
+
+	var key interface{} = []byte(*secretPtr)
+	if len(*keyPtr) > 0 {
+		data, err := os.ReadFile(*keyPtr)
+		if err != nil {
+			return fmt.Errorf("could not read key: %v", err)
+		}
+		if key, err = h.ParseKey(data); err != nil {
+			return fmt.Errorf("invalid key: %v", err)
+		}
+		var jwk h.Jwk
+		if json.Unmarshal(data, &jwk) == nil && len(jwk.Kid) > 0 {
+			header["kid"] = jwk.Kid
+		}
+	}
+	alg := *algPtr
+	if len(alg) == 0 {
+		if alg, err = h.DefaultAlgorithm(key); err != nil {
+			return err
+		}
+	}
+
+	for name, value := range map[string]string{"exp": *expPtr, "iat": *iatPtr, "nbf": *nbfPtr} {
+		if len(value) == 0 {
+			continue
+		}
+		timestamp, err := parseTimeSpec(value, time.Now())
+		if err != nil {
+			return fmt.Errorf("invalid --%v: %v", name, err)
+		}
+		claims[name] = timestamp.Unix()
+	}
+
+	if overrides, err := h.ReadValueOrFile(*headerPtr); err != nil {
+		return fmt.Errorf("could not read header: %v", err)
+	} else if overrides != nil {
+		if err := json.Unmarshal(overrides, &header); err != nil {
+			return fmt.Errorf("header must be a JSON object: %v", err)
+		}
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return fmt.Errorf("could not encode claims: %v", err)
+	}
+	token, err := h.SignJws(header, payload, alg, key)
+	if err != nil {
+		return fmt.Errorf("could not sign token: %v", err)
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// Claims as a JSON object from an argument, a file (@<file>) or stdin
+func readClaimsInput(args []string) (h.Unstruct, error) {
+	var raw []byte
+	var err error
+	if len(args) > 1 {
+		return nil, fmt.Errorf("expected at most one claims argument\n%v", signUsageMsg)
+	} else if len(args) == 1 {
+		raw, err = h.ReadValueOrFile(args[0])
+	} else {
+		raw, err = h.ReadValueOrFile("@-")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read claims: %v", err)
+	}
+	claims := h.Unstruct{}
+	if len(strings.TrimSpace(string(raw))) == 0 {
+		return claims, nil
+	}
+	if err := decodeJson(raw, &claims); err != nil {
+		return nil, fmt.Errorf("claims must be a JSON object: %v", err)
+	}
+	return claims, nil
+}
+
+// A point in time; "now", "+1h" (from now), "now-5m", epoch seconds or RFC 3339
+func parseTimeSpec(spec string, now time.Time) (time.Time, error) {
+	spec = strings.TrimSpace(spec)
+	if epoch, err := strconv.ParseInt(spec, 10, 64); err == nil {
+		return time.Unix(epoch, 0), nil
+	}
+	if timestamp, err := time.Parse(time.RFC3339, spec); err == nil {
+		return timestamp, nil
+	}
+	offset := strings.TrimPrefix(spec, "now")
+	if len(offset) == 0 {
+		return now, nil
+	}
+	if offset[0] != '+' && offset[0] != '-' {
+		return now, fmt.Errorf("unsupported time %q", spec)
+	}
+	duration, err := parseDuration(offset[1:])
+	if err != nil {
+		return now, err
+	}
+	if offset[0] == '-' {
+		duration = -duration
+	}
+	return now.Add(duration), nil
+}
+
+// Like time.ParseDuration, but also with days (e.g. "7d")
+func parseDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		count, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return duration, nil
+}
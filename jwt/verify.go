@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	h "o2token/helpers"
+)
+
+// Verify the signature of a JWT with a key (PEM or JWK), a JWK Set (file or URL) or an HMAC
+// secret, and warn about setups that are known to be dangerous
+
+const verifyUsageMsg = `Usage: jwt verify --key <pem-file|jwk-file|jwks-file|jwks-url> [optional flags] <jwt-string>
+   or: jwt verify --secret <hmac-secret> [optional flags] <jwt-string>`
+
+// Either a single key or a set of keys (to select from by "kid" and "alg")
+type verificationKeys struct {
+	key  interface{}
+	jwks *h.Jwks
+}
+
+func verifyCommand(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyPtr := fs.String("key", "", "Verification key; a PEM or JWK file, a JWKS file or a JWKS URL")
+	secretPtr := fs.String("secret", "", "HMAC secret (for HS256/HS384/HS512)")
+	var trustedHosts stringListFlag
+	fs.Var(&trustedHosts, "trusted-host", "Host that may be referenced by \"jku\"/\"x5u\" headers (repeatable)")
+	fs.Parse(args)
+
+	// The signature of an SD-JWT is the one of its issuer-signed JWT
+	jwtStr, _, err := splitSdJwt(readJwtInput(fs.Args()))
+	if err != nil {
+		return fmt.Errorf("not a valid SD-JWT: %v", err)
+	}
+	token, err := h.DecodeJwt(jwtStr)
+	if err != nil {
+		return fmt.Errorf("not a valid JWT: %v", err)
+	}
+	if (len(*keyPtr) == 0) == (len(*secretPtr) == 0) {
+		return fmt.Errorf("expected either --key or --secret\n%v", verifyUsageMsg)
+	}
+
+	keys := verificationKeys{key: []byte(*secretPtr)}
+	if len(*keyPtr) > 0 {
+		if keys, err = loadVerificationKeys(*keyPtr); err != nil {
+			return err
+		}
+	}
+
+	warnAboutHeaders(token.Header, trustedHosts)
+	if keys, err = checkAlgorithm(token.Header.Alg, keys); err != nil {
+		return fmt.Errorf("signature verification failed: %v", err)
+	}
+
+	keyInfo := "the given key"
+	if keys.jwks != nil {
+		_, key, err := h.VerifyJwsWithJwks(jwtStr, *keys.jwks)
+		if err != nil {
+			return fmt.Errorf("signature verification failed: %v", err)
+		}
+		keyInfo = fmt.Sprintf("key %q", key.Kid)
+	} else if _, err := h.VerifyJws(jwtStr, keys.key); err != nil {
+		return fmt.Errorf("signature verification failed: %v", err)
+	}
+	fmt.Printf("Signature verified (%v, %v)\n", token.Header.Alg, keyInfo)
+	printValidityNotes(token)
+	return nil
+}
+
+func loadVerificationKeys(spec string) (verificationKeys, error) {
+	if strings.HasPrefix(spec, "https://") || strings.HasPrefix(spec, "http://") {
+		jwks, err := h.FetchJwks(spec)
+		return verificationKeys{jwks: &jwks}, err
+	}
+	data, err := os.ReadFile(spec)
+	if err != nil {
+		return verificationKeys{}, fmt.Errorf("could not read key: %v", err)
+	}
+	if strings.Contains(string(data), "-----BEGIN") {
+		key, err := h.ParsePemKey(data)
+		if err != nil {
+			return verificationKeys{}, fmt.Errorf("invalid PEM key: %v", err)
+		}
+		return verificationKeys{key: key}, nil
+	}
+	jwks, err := h.ParseJwks(data)
+	if err != nil {
+		return verificationKeys{}, fmt.Errorf("invalid key file %v: %v", spec, err)
+	}
+	return verificationKeys{jwks: &jwks}, nil
+}
+
+// Reject unsecured tokens and HMAC tokens "signed" with a public key (algorithm confusion);
+// for HMAC tokens only the symmetric keys of a JWKS are candidates
+func checkAlgorithm(alg string, keys verificationKeys) (verificationKeys, error) {
+	if alg == "none" {
+		return keys, fmt.Errorf("unsecured token (alg: none) must never be accepted")
+	}
+	if !strings.HasPrefix(alg, "HS") {
+		return keys, nil
+	}
+	switch keys.key.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey, *ecdsa.PublicKey, *ecdsa.PrivateKey, ed25519.PublicKey, ed25519.PrivateKey:
+		return keys, fmt.Errorf("%v token with a public key; this is the classic algorithm confusion attack (the public key must never be used as an HMAC secret)", alg)
+	}
+	if keys.jwks == nil {
+		return keys, nil
+	}
+	symmetric := h.Jwks{}
+	for _, key := range keys.jwks.Keys {
+		if key.Kty == "oct" {
+			symmetric.Keys = append(symmetric.Keys, key)
+		}
+	}
+	if len(symmetric.Keys) == 0 {
+		return keys, fmt.Errorf("%v token but no symmetric (\"oct\") key in the JWKS; public keys must never be used as HMAC secrets (algorithm confusion attack)", alg)
+	}
+	return verificationKeys{jwks: &symmetric}, nil
+}
+
+// Headers that (try to) tell where the verification key is found must not be trusted blindly
+func warnAboutHeaders(header h.JoseHeader, trustedHosts []string) {
+	for name, value := range map[string]string{"jku": header.Jku, "x5u": header.X5u} {
+		if len(value) == 0 {
+			continue
+		}
+		target, err := url.Parse(value)
+		if err != nil || !isTrustedHost(target.Hostname(), trustedHosts) {
+			fmt.Fprintf(os.Stderr, "WARNING: %q header points at an untrusted location (%v); keys must never be fetched from it unless the host is trusted\n", name, value)
+		} else if target.Scheme != "https" {
+			fmt.Fprintf(os.Stderr, "WARNING: %q header doesn't use HTTPS (%v)\n", name, value)
+		}
+	}
+	if header.Jwk != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: embedded \"jwk\" header; anyone can sign a token with their own key and embed it\n")
+	}
+}
+
+func isTrustedHost(host string, trustedHosts []string) bool {
+	for _, trusted := range trustedHosts {
+		if strings.EqualFold(host, trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// The signature says nothing about the token still being valid
+func printValidityNotes(token h.JwtSections) {
+	claims, err := h.JwtClaims(token.SigningInput + ".")
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0)) {
+		fmt.Printf("Note: the token expired at %v\n", time.Unix(int64(exp), 0))
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0)) {
+		fmt.Printf("Note: the token is not valid before %v\n", time.Unix(int64(nbf), 0))
+	}
+}
+
+type stringListFlag []string
+
+func (l *stringListFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringListFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	h "o2token/helpers"
+)
+
+// The certificate chain of the "x5c" header (the first certificate contains the signing key),
+// checked against the "x5t" and "x5t#S256" thumbprints and optionally a CA bundle
+// 👉 https://datatracker.ietf.org/doc/html/rfc7515#section-4.1.6
+
+func describeCertificates(header h.JoseHeader, caBundle string) string {
+	certificates := []*x509.Certificate{}
+	sections := []string{}
+	for i, encoded := range header.X5c {
+		// Standard base64 (not base64url) of the DER encoding
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			sections = append(sections, fmt.Sprintf("Certificate %v: invalid base64: %v", i+1, err))
+			continue
+		}
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			sections = append(sections, fmt.Sprintf("Certificate %v: invalid certificate: %v", i+1, err))
+			continue
+		}
+		certificates = append(certificates, certificate)
+		lines := describeCertificate(i+1, certificate)
+		if i == 0 {
+			lines = append(lines, checkThumbprint("x5t", header.X5t, sha1Thumbprint(der))...)
+			lines = append(lines, checkThumbprint("x5t#S256", header.X5tS256, sha256Thumbprint(der))...)
+		}
+		sections = append(sections, strings.Join(lines, "\n"))
+	}
+	if len(certificates) == len(header.X5c) && len(certificates) > 0 {
+		sections = append(sections, validateChain(certificates, caBundle))
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+func describeCertificate(position int, certificate *x509.Certificate) []string {
+	validity := fmt.Sprintf("Valid: %v until %v", certificate.NotBefore.UTC(), certificate.NotAfter.UTC())
+	now := time.Now()
+	if now.After(certificate.NotAfter) {
+		validity += " (EXPIRED)"
+	} else if now.Before(certificate.NotBefore) {
+		validity += " (NOT YET VALID)"
+	}
+	return []string{
+		fmt.Sprintf("Certificate %v:", position),
+		fmt.Sprintf("Subject: %v", certificate.Subject),
+		fmt.Sprintf("Issuer: %v", certificate.Issuer),
+		validity,
+		fmt.Sprintf("Key: %v", describePublicKey(certificate.PublicKey)),
+		fmt.Sprintf("SHA-1 thumbprint: %X (base64url: %v)", sha1.Sum(certificate.Raw), sha1Thumbprint(certificate.Raw)),
+		fmt.Sprintf("SHA-256 thumbprint: %X (base64url: %v)", sha256.Sum256(certificate.Raw), sha256Thumbprint(certificate.Raw)),
+	}
+}
+
+func describePublicKey(key interface{}) string {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA (%v bits)", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("EC (%v)", key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return fmt.Sprintf("unknown (%T)", key)
+}
+
+func sha1Thumbprint(der []byte) string {
+	digest := sha1.Sum(der)
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+func sha256Thumbprint(der []byte) string {
+	digest := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+func checkThumbprint(name string, expected string, actual string) []string {
+	if len(expected) == 0 {
+		return nil
+	}
+	if strings.TrimRight(expected, "=") != actual {
+		return []string{fmt.Sprintf("WARNING: %q header (%v) doesn't match the certificate", name, expected)}
+	}
+	return []string{fmt.Sprintf("%q header: matches the certificate", name)}
+}
+
+// Without a CA bundle only the signatures within the chain are checked
+func validateChain(certificates []*x509.Certificate, caBundle string) string {
+	if len(caBundle) == 0 {
+		for i, certificate := range certificates[:len(certificates)-1] {
+			if err := certificate.CheckSignatureFrom(certificates[i+1]); err != nil {
+				return fmt.Sprintf("WARNING: certificate %v is not issued by certificate %v: %v", i+1, i+2, err)
+			}
+		}
+		return "Chain: not validated (no CA bundle given, see --ca-bundle)"
+	}
+
+	data, err := os.ReadFile(caBundle)
+	if err != nil {
+		return fmt.Sprintf("WARNING: could not read CA bundle: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return fmt.Sprintf("WARNING: no certificates found in CA bundle %v", caBundle)
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	chains, err := certificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Sprintf("WARNING: chain not valid: %v", err)
+	}
+	chain := chains[0]
+	return fmt.Sprintf("Chain: valid (trusted root: %v)", chain[len(chain)-1].Subject)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 )
@@ -8,33 +9,57 @@ import (
 var appConfig AppConfig
 var exitCode int
 
+// Subcommands are selected via the first CLI argument and handle their own flags
+// (without a subcommand, tokens are obtained and printed to stdout)
+var subcommands = map[string]func(args []string) error{
+	"agent":             agentCommand,
+	"call":              callCommand,
+	"docker-credential": dockerCredentialCommand,
+	"get":               getCommand,
+	"git-credential":    gitCredentialCommand,
+	"proxy":             proxyCommand,
+}
+
 func main() {
 	defer func() {
 		os.Exit(exitCode)
 	}()
 
+	if isDockerCredentialHelper() {
+		if err := dockerCredentialCommand(os.Args[1:]); err != nil {
+			exitWithError(dockerCredentialHelperName, err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 {
+		if command, found := subcommands[os.Args[1]]; found {
+			if err := command(os.Args[2:]); err != nil {
+				exitWithError(os.Args[1], err)
+			}
+			return
+		}
+	}
+
 	var err error
-	appConfig, err = initializeAppConfig()
+	appConfig, err = initializeAppConfig(flag.CommandLine, os.Args[1:], nil)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: invalid/incomplete application configuration: %v\n", err)
-		os.Exit(1)
+		exitWithError("invalid/incomplete application configuration", err)
 	}
 
 	if appConfig.ClientCredFlow {
 		err := clientCredFlow()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: client credentials flow failed: %v\n", err)
-			os.Exit(1)
+			exitWithError("client credentials flow failed", err)
 		}
 	} else if appConfig.RefreshToken != "" {
 		err := refreshTokens(appConfig.RefreshToken)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: token refresh failed: %v\n", err)
-			os.Exit(1)
+			exitWithError("token refresh failed", err)
 		}
-	} else {
-		serveAuthCodeFlow()
+	} else if err := serveAuthCodeFlow(); err != nil {
+		exitWithError("authorization code flow failed", err)
 	}
 }
 
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// Structured OAuth2 error responses with exit codes and hints for known errors
+// 👉 https://datatracker.ietf.org/doc/html/rfc6749#section-5.2 (token endpoint)
+// 👉 https://datatracker.ietf.org/doc/html/rfc6749#section-4.1.2.1 (authorization endpoint)
+// 👉 https://openid.net/specs/openid-connect-core-1_0.html#AuthError
+
+type OAuthError struct {
+	Code        string   `json:"error"`
+	Description string   `json:"error_description,omitempty"`
+	URI         string   `json:"error_uri,omitempty"`
+	VendorCodes []string `json:"vendor_codes,omitempty"` // e.g. Azure "AADSTS" numbers
+	StatusCode  int      `json:"status,omitempty"`       // HTTP status (not available for callback errors)
+	GrantType   string   `json:"grant_type,omitempty"`   // the failed token request (if any)
+	Hint        string   `json:"hint,omitempty"`
+	ExitCode    int      `json:"exit_code"`
+}
+
+// Exit codes per error code (anything else, including non-OAuth errors, exits with 1)
+var oauthErrorExitCodes = map[string]int{
+	"invalid_request":               10,
+	"invalid_client":                11,
+	"invalid_grant":                 12,
+	"unauthorized_client":           13,
+	"unsupported_grant_type":        14,
+	"invalid_scope":                 15,
+	"access_denied":                 16,
+	"unsupported_response_type":     17,
+	"interaction_required":          18,
+	"login_required":                18,
+	"consent_required":              18,
+	"account_selection_required":    18,
+	"invalid_target":                19, // RFC 8707
+	"invalid_authorization_details": 20, // RFC 9396
+	"invalid_dpop_proof":            21, // RFC 9449
+	"use_dpop_nonce":                21,
+	"server_error":                  22,
+	"temporarily_unavailable":       22,
+	"audience_mismatch":             23, // detected by o2token, not an IDP error
+}
+
+// An unknown (but well-formed) OAuth error
+const oauthErrorExitCode = 9
+
+var oauthErrorHints = map[string]string{
+	"invalid_request":               "the request is missing a parameter or is otherwise malformed; check the configuration with --verbose",
+	"invalid_client":                "client authentication failed; check --client-id and --client-secret (public clients must not send a secret)",
+	"unauthorized_client":           "the client is not allowed to use this grant type; check the app registration",
+	"unsupported_grant_type":        "the IDP doesn't support this grant type",
+	"invalid_scope":                 "the requested scope is invalid or unknown; check --scope",
+	"access_denied":                 "the user or the IDP denied the request",
+	"unsupported_response_type":     "the IDP doesn't allow this response type for the client",
+	"interaction_required":          "the user must interact with the IDP; don't use prompt=none",
+	"login_required":                "the user must log in; don't use prompt=none",
+	"consent_required":              "the user (or an admin) must consent to the requested scopes",
+	"invalid_target":                "the requested resource is invalid or unknown; check --resource and --audience",
+	"invalid_authorization_details": "the authorization details are invalid; check --authorization-details",
+	"invalid_dpop_proof":            "the DPoP proof was rejected; check the system clock and --dpop",
+	"server_error":                  "the IDP had an internal problem; try again later",
+	"temporarily_unavailable":       "the IDP is temporarily unavailable; try again later",
+	"audience_mismatch":             "the IDP ignored the requested resource/audience; check the API/client registration, or use --allow-wrong-audience",
+}
+
+var invalidGrantHints = map[string]string{
+	"refresh_token":      "refresh token expired or revoked — re-run without --refresh-token",
+	"authorization_code": "authorization code expired or already used, or mismatching redirect URI/PKCE verifier",
+}
+
+// A few well-known Azure AD errors (the descriptions are usually more cryptic)
+var vendorErrorHints = map[string]string{
+	"AADSTS50011":   "the redirect URI is not registered for the app; add http://localhost:<port><callback-path>",
+	"AADSTS50173":   "the grant was revoked (e.g. after a password change) — log in again",
+	"AADSTS65001":   "consent is missing for the app or the requested scopes",
+	"AADSTS70011":   "invalid scope; Azure requires a single resource per request (e.g. api://x/.default)",
+	"AADSTS700016":  "the client ID is not known in this tenant; check --client-id and the endpoints",
+	"AADSTS700082":  "refresh token expired due to inactivity — re-run without --refresh-token",
+	"AADSTS7000215": "invalid client secret; check that the secret value (not its ID) is used",
+	"AADSTS9002327": "tokens for SPA registrations can only be redeemed via cross-origin requests; use a web/mobile platform",
+}
+
+var vendorCodePattern = regexp.MustCompile(`AADSTS\d+`)
+
+func (e *OAuthError) Error() string {
+	msg := e.Code
+	if len(e.Description) > 0 {
+		msg = fmt.Sprintf("%v: %v", e.Code, e.Description)
+	}
+	if e.StatusCode != 0 {
+		msg = fmt.Sprintf("%v (HTTP status %v)", msg, e.StatusCode)
+	}
+	if len(e.Hint) > 0 {
+		msg = fmt.Sprintf("%v\n👉 %v", msg, e.Hint)
+	}
+	return msg
+}
+
+// Parse an error response body (nil if the body doesn't contain an OAuth error)
+func parseOAuthError(body []byte, statusCode int, grantType string) *OAuthError {
+	var fields struct {
+		Code        string          `json:"error"`
+		Description string          `json:"error_description"`
+		URI         string          `json:"error_uri"`
+		ErrorCodes  json.RawMessage `json:"error_codes"` // Azure AD (numeric AADSTS codes)
+	}
+	if err := json.Unmarshal(body, &fields); err != nil || len(fields.Code) == 0 {
+		return nil
+	}
+	oauthErr := newOAuthError(fields.Code, fields.Description, fields.URI)
+	oauthErr.StatusCode = statusCode
+	oauthErr.GrantType = grantType
+
+	var numericCodes []int
+	if json.Unmarshal(fields.ErrorCodes, &numericCodes) == nil {
+		for _, code := range numericCodes {
+			oauthErr.addVendorCode("AADSTS" + strconv.Itoa(code))
+		}
+	}
+	if hint, found := invalidGrantHints[grantType]; found && oauthErr.Code == "invalid_grant" && len(oauthErr.Hint) == 0 {
+		oauthErr.Hint = hint
+	}
+	return oauthErr
+}
+
+// Error from the authorization endpoint (i.e. via the callback) or the token endpoint
+func newOAuthError(code string, description string, uri string) *OAuthError {
+	oauthErr := &OAuthError{Code: code, Description: description, URI: uri, ExitCode: oauthErrorExitCode}
+	if exitCode, found := oauthErrorExitCodes[code]; found {
+		oauthErr.ExitCode = exitCode
+	}
+	for _, vendorCode := range vendorCodePattern.FindAllString(description, -1) {
+		oauthErr.addVendorCode(vendorCode)
+	}
+	if len(oauthErr.Hint) == 0 {
+		oauthErr.Hint = oauthErrorHints[code]
+	}
+	return oauthErr
+}
+
+// Vendor specific hints have precedence over the generic ones
+func (e *OAuthError) addVendorCode(code string) {
+	for _, existing := range e.VendorCodes {
+		if existing == code {
+			return
+		}
+	}
+	e.VendorCodes = append(e.VendorCodes, code)
+	if hint, found := vendorErrorHints[code]; found {
+		e.Hint = hint
+	}
+}
+
+// Exit code for an error (1 unless it is, or wraps, an OAuth error)
+func errorExitCode(err error) int {
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) {
+		return oauthErr.ExitCode
+	}
+	return 1
+}
+
+// Report an error on stderr, either as text or (for scripts) as JSON
+func reportError(label string, err error) {
+	// The configuration may not be initialized (e.g. when it is the cause of the error)
+	if !appConfig.ErrorJson && !parseBoolEnvVar(false, "O2TOKEN_ERROR_JSON") {
+		fmt.Fprintf(os.Stderr, "ERROR: %v: %v\n", label, err)
+		return
+	}
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		oauthErr = &OAuthError{Code: "o2token_error", ExitCode: 1}
+	}
+	report := struct {
+		*OAuthError
+		Message string `json:"message"`
+	}{oauthErr, fmt.Sprintf("%v: %v", label, err)}
+	reportJson, _ := json.Marshal(report)
+	fmt.Fprintln(os.Stderr, string(reportJson))
+}
+
+// Report an error and exit (immediately) with the corresponding exit code
+func exitWithError(label string, err error) {
+	reportError(label, err)
+	os.Exit(errorExitCode(err))
+}
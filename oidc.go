@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	_ "embed"
 	"encoding/base64"
@@ -11,6 +12,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -18,25 +20,119 @@ import (
 )
 
 type OAuthAccessResponse struct {
-	TokenType    string     `json:"token_type"`
-	Scope        string     `json:"scope"`
-	ExpiresIn    int        `json:"expires_in"`
-	AccessToken  string     `json:"access_token"`
-	RefreshToken string     `json:"refresh_token"`
-	IDToken      string     `json:"id_token"`
-	UserInfo     h.Unstruct `json:"userinfo,omitempty"` // actually not part of the oauth2 token response but added for (output) convenience
+	TokenType            string                     `json:"token_type"`
+	Scope                string                     `json:"scope"`
+	ExpiresIn            int                        `json:"expires_in"`
+	AccessToken          string                     `json:"access_token"`
+	RefreshToken         string                     `json:"refresh_token"`
+	IDToken              string                     `json:"id_token"`
+	AuthorizationDetails json.RawMessage            `json:"authorization_details,omitempty"` // RFC 9396
+	UserInfo             h.Unstruct                 `json:"userinfo,omitempty"`              // actually not part of the oauth2 token response but added for (output) convenience
+	Extra                map[string]json.RawMessage `json:"-"`                               // nonstandard (vendor) fields, kept as is
+}
+
+// Unmarshal the typed fields and keep everything else in Extra
+func (r *OAuthAccessResponse) UnmarshalJSON(data []byte) error {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	// Some IDPs (e.g. Azure AD v1) send expires_in as a string
+	if expiresIn, found := all["expires_in"]; found && strings.HasPrefix(string(expiresIn), `"`) {
+		all["expires_in"] = json.RawMessage(strings.Trim(string(expiresIn), `"`))
+		var err error
+		if data, err = json.Marshal(all); err != nil {
+			return fmt.Errorf("invalid expires_in %v: %v", string(expiresIn), err)
+		}
+	}
+
+	type typedFields OAuthAccessResponse // without the custom (un)marshaling
+	if err := json.Unmarshal(data, (*typedFields)(r)); err != nil {
+		return err
+	}
+	r.Extra = nil
+	for name, value := range all {
+		if !isStandardTokenResponseField(name) {
+			if r.Extra == nil {
+				r.Extra = map[string]json.RawMessage{}
+			}
+			r.Extra[name] = value
+		}
+	}
+	return nil
+}
+
+// Marshal the typed fields followed by the extra fields (sorted by name)
+func (r OAuthAccessResponse) MarshalJSON() ([]byte, error) {
+	type typedFields OAuthAccessResponse // without the custom (un)marshaling
+	typed, err := json.Marshal(typedFields(r))
+	if err != nil || len(r.Extra) == 0 {
+		return typed, err
+	}
+	var result bytes.Buffer
+	result.Write(typed[:len(typed)-1]) // skip the closing brace
+	for _, name := range r.extraFieldNames() {
+		nameJson, _ := json.Marshal(name)
+		result.WriteString(",")
+		result.Write(nameJson)
+		result.WriteString(":")
+		result.Write(r.Extra[name])
+	}
+	result.WriteString("}")
+	return result.Bytes(), nil
+}
+
+func (r OAuthAccessResponse) extraFieldNames() []string {
+	names := make([]string, 0, len(r.Extra))
+	for name := range r.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Typed access to a nonstandard numeric field, e.g. "refresh_token_expires_in"
+func (r OAuthAccessResponse) ExtraInt(name string) (int, bool) {
+	var value json.Number
+	raw, found := r.Extra[name]
+	if !found || json.Unmarshal(([]byte)(strings.Trim(string(raw), `"`)), &value) != nil {
+		return 0, false
+	}
+	intValue, err := value.Int64()
+	return int(intValue), err == nil
+}
+
+// Fields defined by RFC 6749, OIDC Core and RFC 9396 (and our own "userinfo")
+func isStandardTokenResponseField(name string) bool {
+	switch name {
+	case "token_type", "scope", "expires_in", "access_token", "refresh_token", "id_token", "authorization_details", "userinfo":
+		return true
+	}
+	return false
 }
 
 // Only a few fields defined here (the ones used by the app)
 type OidcMetadata struct {
+	Issuer           string `json:"issuer"`
 	AuthEndpoint     string `json:"authorization_endpoint"`
 	TokenEndpoint    string `json:"token_endpoint"`
 	UserInfoEndpoint string `json:"userinfo_endpoint"`
+	JwksUri          string `json:"jwks_uri"`
+	ParEndpoint      string `json:"pushed_authorization_request_endpoint"`
+
+	AuthorizationResponseIssParameterSupported bool `json:"authorization_response_iss_parameter_supported"`
+	RequirePushedAuthorizationRequests         bool `json:"require_pushed_authorization_requests"`
 }
 
 //go:embed html/success.html
 var successPage string
 
+//go:embed html/fragment.html
+var fragmentPage string
+
+// Invoked with the redeemed tokens at the end of a successful code flow (default: print them)
+var codeFlowCompleted = printTokens
+
 func fetchMetadataDocument(metadataUrl string) OidcMetadata {
 	empty := OidcMetadata{}
 	httpClient := http.Client{}
@@ -71,41 +167,160 @@ func fetchMetadataDocument(metadataUrl string) OidcMetadata {
 
 func startFlow(w http.ResponseWriter, r *http.Request) {
 	// Redirect to authorization endpoint
-	redirectUri := fmt.Sprintf("http://localhost:%v%v", appConfig.Port, appConfig.CallbackPath)
-	scope := url.QueryEscape(appConfig.Scope)
-	url := fmt.Sprintf("%v?client_id=%v&redirect_uri=%v&scope=%v&response_type=code&state=%v", appConfig.AuthEndpoint, appConfig.ClientID, redirectUri, scope, appConfig.State)
+	params := url.Values{}
+	params.Set("client_id", appConfig.ClientID)
+	params.Set("redirect_uri", redirectUri())
+	params.Set("scope", appConfig.Scope)
+	params.Set("response_type", appConfig.ResponseType)
+	params.Set("state", appConfig.State)
+	if len(appConfig.Nonce) > 0 {
+		params.Set("nonce", appConfig.Nonce)
+	}
+	if len(appConfig.ResponseMode) > 0 {
+		params.Set("response_mode", appConfig.ResponseMode)
+	}
 	if appConfig.Pkce {
-		url = fmt.Sprintf("%v&code_challenge=%v&code_challenge_method=S256", url, appConfig.CodeChallenge)
+		params.Set("code_challenge", appConfig.CodeChallenge)
+		params.Set("code_challenge_method", "S256")
+	}
+	if len(appConfig.AcrValues) > 0 {
+		params.Set("acr_values", appConfig.AcrValues)
+	}
+	if len(appConfig.MaxAge) > 0 {
+		params.Set("max_age", appConfig.MaxAge)
+	}
+	if len(appConfig.Prompt) > 0 {
+		params.Set("prompt", appConfig.Prompt)
+	}
+	if len(appConfig.LoginHint) > 0 {
+		params.Set("login_hint", appConfig.LoginHint)
+	}
+	if len(appConfig.UiLocales) > 0 {
+		params.Set("ui_locales", appConfig.UiLocales)
+	}
+	if len(appConfig.Claims) > 0 {
+		params.Set("claims", appConfig.Claims)
+	}
+	addTargetParams(params)
+	addCustomParams(params, appConfig.AuthParams)
+
+	// With PAR, the parameters are pushed to the IDP and only a reference to them is sent via the browser
+	if appConfig.Par {
+		requestUri, err := pushAuthorizationRequest(params)
+		if err != nil {
+			reportErrorAndSoftExit("pushed authorization request failed", err, http.StatusBadGateway, w)
+			return
+		}
+		params = url.Values{}
+		params.Set("client_id", appConfig.ClientID)
+		params.Set("request_uri", requestUri)
 	}
+
+	separator := "?"
+	if strings.Contains(appConfig.AuthEndpoint, "?") {
+		separator = "&" // e.g. an Azure B2C policy parameter
+	}
+	url := appConfig.AuthEndpoint + separator + params.Encode()
 	if appConfig.Verbose {
 		fmt.Printf("Redirecting user to authorization endpoint:\n%v\n", url)
 	}
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
+// Send the authorization request parameters to the PAR endpoint, returning the "request_uri"
+// to use in the authorization request
+// 👉 https://datatracker.ietf.org/doc/html/rfc9126
+func pushAuthorizationRequest(params url.Values) (string, error) {
+	pushed := url.Values{}
+	for key, values := range params {
+		pushed[key] = values
+	}
+	if len(appConfig.ClientSecret) > 0 {
+		pushed.Set("client_secret", appConfig.ClientSecret)
+	}
+
+	res, err := postForm(appConfig.ParEndpoint, pushed)
+	if err != nil {
+		return "", fmt.Errorf("could not send pushed authorization request: %v", err)
+	}
+	defer res.Body.Close()
+	if appConfig.Verbose {
+		fmt.Printf("Sent pushed authorization request to %v\n", appConfig.ParEndpoint)
+	}
+
+	bodyBytes, _ := io.ReadAll(res.Body)
+	if oauthErr := parseOAuthError(bodyBytes, res.StatusCode, ""); oauthErr != nil {
+		return "", oauthErr
+	}
+	if res.StatusCode >= 400 {
+		return "", fmt.Errorf("unexpected status code %v from PAR endpoint, raw body: %v", res.StatusCode, string(bodyBytes))
+	}
+	var parResponse struct {
+		RequestUri string `json:"request_uri"`
+	}
+	if err := json.Unmarshal(bodyBytes, &parResponse); err != nil || len(parResponse.RequestUri) == 0 {
+		return "", fmt.Errorf("no request_uri in response from PAR endpoint, raw body: %v", string(bodyBytes))
+	}
+	return parResponse.RequestUri, nil
+}
+
+func redirectUri() string {
+	return fmt.Sprintf("http://localhost:%v%v", appConfig.Port, appConfig.CallbackPath)
+}
+
 func oauth2CodeCallback(w http.ResponseWriter, r *http.Request) {
+	// Response modes other than "query" deliver the parameters in a POST body
+	// 👉 https://openid.net/specs/oauth-v2-form-post-response-mode-1_0.html
+	// 👉 https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#ResponseModes
+	switch responseMode := strings.TrimSuffix(effectiveResponseMode(), ".jwt"); {
+	case responseMode == "fragment" && r.Method == http.MethodGet && len(r.URL.RawQuery) == 0:
+		// The browser keeps the fragment to itself; let a script post it back
+		serveString(fragmentPage, w)
+		return
+	case responseMode == "form_post" && r.Method != http.MethodPost:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(([]byte)("ERROR: expected a POST request (response_mode=form_post)"))
+		return
+	}
+
 	if appConfig.Verbose {
 		fmt.Printf("Processing callback for authorization code\n")
 	}
 
-	// Parse query parameters
+	// Parse query (or form) parameters
 	err := r.ParseForm()
 	if err != nil {
 		reportErrorAndSoftExit("could not parse query in callback", err, http.StatusBadRequest, w)
 		return
 	}
 
+	// Unwrap the actual response parameters if they are delivered as a JWT
+	if isJarmResponseMode(appConfig.ResponseMode) {
+		params, err := jarmResponseParams(r.FormValue("response"))
+		if err != nil {
+			reportErrorAndSoftExit("invalid JWT secured authorization response", err, http.StatusBadRequest, w)
+			return
+		}
+		r.Form = params
+	}
+
+	// Make sure the response is from the expected IDP (also for error responses)
+	if err := checkResponseIssuer(r.Form); err != nil {
+		reportErrorAndSoftExit("unexpected issuer of authorization response", err, http.StatusBadRequest, w)
+		return
+	}
+
 	// First check that we didn't encounter and error
 	errorParam := r.FormValue("error")
-	errorDescriptionParam := r.FormValue("error_description")
 	if len(errorParam) != 0 {
-		reportErrorAndSoftExit("error response from identity provider", fmt.Errorf("%v: %v", errorParam, errorDescriptionParam), http.StatusBadRequest, w)
+		err := newOAuthError(errorParam, r.FormValue("error_description"), r.FormValue("error_uri"))
+		reportErrorAndSoftExit("error response from identity provider", err, http.StatusBadRequest, w)
 		return
 	}
 
-	// Fetch the "code" param needed to redeem the token(s)
+	// Fetch the "code" param needed to redeem the token(s) (unless only tokens are requested)
 	code := r.FormValue("code")
-	if len(code) == 0 {
+	if len(code) == 0 && responseTypeIncludes("code") {
 		reportErrorAndSoftExit("oauth2 flow error", fmt.Errorf("missing 'code' parameter"), http.StatusBadRequest, w)
 		return
 	}
@@ -118,14 +333,28 @@ func oauth2CodeCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Next, call the idp oauth2 token endpoint to get our tokens
-	tokens, err := redeemTokensWithCode(code)
+	// Tokens returned directly in the response (implicit/hybrid flows) are validated first
+	tokens, err := frontChannelTokens(r, code)
 	if err != nil {
-		reportErrorAndSoftExit("oauth2 flow error", err, http.StatusInternalServerError, w)
+		reportErrorAndSoftExit("invalid authorization response", err, http.StatusBadRequest, w)
 		return
 	}
 
-	if appConfig.UserInfo {
+	// Next, call the idp oauth2 token endpoint to get our tokens
+	if responseTypeIncludes("code") {
+		redeemed, err := redeemTokensWithCode(code)
+		if err != nil {
+			reportErrorAndSoftExit("oauth2 flow error", err, http.StatusInternalServerError, w)
+			return
+		}
+		if err := validateRedeemedIdToken(redeemed.IDToken); err != nil {
+			reportErrorAndSoftExit("invalid token response", err, http.StatusBadRequest, w)
+			return
+		}
+		tokens = mergeTokens(redeemed, tokens)
+	}
+
+	if appConfig.UserInfo && len(tokens.AccessToken) > 0 {
 		var err error
 		tokens.UserInfo, err = fetchUserInfo(tokens.AccessToken)
 		if err != nil {
@@ -136,8 +365,8 @@ func oauth2CodeCallback(w http.ResponseWriter, r *http.Request) {
 	// Finally, send the "success" page as a response
 	serveString(successPage, w)
 
-	// Print result to stdout
-	err = printTokens(tokens)
+	// Print result to stdout (or hand it over to whoever started the flow)
+	err = codeFlowCompleted(tokens)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: Output error: %v\n", err)
 		softExit(1)
@@ -147,10 +376,40 @@ func oauth2CodeCallback(w http.ResponseWriter, r *http.Request) {
 	softExit(0)
 }
 
+// Authorization server issuer identification, i.e. protection against mix-up attacks
+// 👉 https://datatracker.ietf.org/doc/html/rfc9207
+func checkResponseIssuer(params url.Values) error {
+	iss, found := params["iss"]
+	if !found {
+		if appConfig.IssParameterRequired {
+			return fmt.Errorf("missing 'iss' parameter (the IDP claims to always include it)")
+		}
+		if appConfig.Verbose {
+			fmt.Printf("No 'iss' parameter in authorization response (issuer not identified)\n")
+		}
+		return nil
+	}
+	if len(appConfig.Issuer) == 0 {
+		// E.g. manually configured endpoints (no metadata); only strict setups insist on a check
+		if appConfig.IssParameterRequired {
+			return fmt.Errorf("'iss' parameter %q can't be checked (no issuer configured)", iss[0])
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: 'iss' parameter %q not checked (no issuer configured)\n", iss[0])
+		return nil
+	}
+	if len(iss) != 1 || iss[0] != appConfig.Issuer {
+		return fmt.Errorf("expected: %v, got: %v", appConfig.Issuer, strings.Join(iss, ", "))
+	}
+	if appConfig.Verbose {
+		fmt.Printf("Verified 'iss' parameter in authorization response: %v\n", iss[0])
+	}
+	return nil
+}
+
 func clientCredFlow() error {
 	tokens, err := redeemTokensWithClientCredentials()
 	if err != nil {
-		return fmt.Errorf("could not redeem tokens: %v", err)
+		return fmt.Errorf("could not redeem tokens: %w", err)
 	}
 
 	// Print result to stdout
@@ -165,7 +424,7 @@ func clientCredFlow() error {
 func refreshTokens(refreshToken string) error {
 	tokens, err := redeemTokensWithRefreshToken(refreshToken)
 	if err != nil {
-		return fmt.Errorf("could not redeem tokens: %v", err)
+		return fmt.Errorf("could not redeem tokens: %w", err)
 	}
 
 	if appConfig.UserInfo {
@@ -197,30 +456,70 @@ func printTokens(tokens OAuthAccessResponse) error {
 
 	if appConfig.Verbose {
 		fmt.Printf("\nSuccessful operation, received tokens expire in %v\n", h.SecondsToFriendlyString(tokens.ExpiresIn))
-		interpret := func(token string) string {
-			epochKeys := []string{"iat", "nbf", "exp", "xms_tcdt"} // xms_tcdt is probably azure proprietary
-			return h.InjectEpochFieldComments(h.PrettyJson(h.JwtToString(token)), epochKeys)
-		}
 		if len(tokens.AccessToken) > 0 {
-			fmt.Printf("\nAccessToken:\n------------\n%v\n", interpret(tokens.AccessToken))
+			fmt.Printf("\nAccessToken:\n------------\n%v\n", interpretJwt(tokens.AccessToken))
 		}
 		if len(tokens.IDToken) > 0 {
-			fmt.Printf("\nID-Token:\n---------\n%v\n", interpret(tokens.IDToken))
+			fmt.Printf("\nID-Token:\n---------\n%v\n", interpretJwt(tokens.IDToken))
+		}
+		if len(appConfig.AuthorizationDetails) > 0 || len(tokens.AuthorizationDetails) > 0 {
+			printAuthorizationDetails(tokens)
+		}
+		if len(tokens.Extra) > 0 {
+			printExtraFields(tokens)
 		}
 	}
 	return nil
 }
 
+// Nonstandard fields with an interpretation of durations (e.g. "ext_expires_in")
+func printExtraFields(tokens OAuthAccessResponse) {
+	fmt.Printf("\nNonstandard fields in token response:\n-------------------------------------\n")
+	for _, name := range tokens.extraFieldNames() {
+		line := fmt.Sprintf("%v: %v", name, string(tokens.Extra[name]))
+		if seconds, ok := tokens.ExtraInt(name); ok && strings.HasSuffix(name, "expires_in") {
+			line += fmt.Sprintf(" //👈 %v", h.SecondsToFriendlyString(seconds))
+		}
+		fmt.Println(line)
+	}
+}
+
+// Requested vs. granted authorization details (as echoed in the response and the access token)
+func printAuthorizationDetails(tokens OAuthAccessResponse) {
+	show := func(details string) string {
+		if len(details) == 0 {
+			return "<none>"
+		}
+		return h.PrettyJson(details)
+	}
+	granted := ""
+	if claims, err := h.JwtClaims(tokens.AccessToken); err == nil && claims["authorization_details"] != nil {
+		grantedBytes, _ := json.Marshal(claims["authorization_details"])
+		granted = string(grantedBytes)
+	}
+	fmt.Printf("\nAuthorization details:\n----------------------\n")
+	fmt.Printf("Requested:\n%v\n", show(appConfig.AuthorizationDetails))
+	fmt.Printf("In token response:\n%v\n", show(string(tokens.AuthorizationDetails)))
+	fmt.Printf("In access token:\n%v\n", show(granted))
+}
+
+// Decoded and annotated JWT body
+func interpretJwt(token string) string {
+	payload := h.JwtToString(token)
+	return h.AnnotateJson(payload, h.ClaimAnnotators([]byte(payload))...)
+}
+
 func redeemTokensWithCode(code string) (OAuthAccessResponse, error) {
 	params := url.Values{}
 	params.Set("grant_type", "authorization_code")
-	params.Set("redirect_uri", fmt.Sprintf("http://localhost:%v%v", appConfig.Port, appConfig.CallbackPath))
+	params.Set("redirect_uri", redirectUri())
 	params.Set("client_id", appConfig.ClientID)
 	params.Set("client_secret", appConfig.ClientSecret)
 	params.Set("code", code)
 	if appConfig.Pkce {
 		params.Set("code_verifier", appConfig.CodeVerifier)
 	}
+	addTargetParams(params)
 
 	return redeemTokens(params)
 }
@@ -231,6 +530,7 @@ func redeemTokensWithClientCredentials() (OAuthAccessResponse, error) {
 	params.Set("client_id", appConfig.ClientID)
 	params.Set("client_secret", appConfig.ClientSecret)
 	params.Set("scope", appConfig.Scope)
+	addTargetParams(params)
 
 	return redeemTokens(params)
 }
@@ -241,24 +541,16 @@ func redeemTokensWithRefreshToken(token string) (OAuthAccessResponse, error) {
 	params.Set("client_id", appConfig.ClientID)
 	params.Set("client_secret", appConfig.ClientSecret)
 	params.Set("refresh_token", token)
+	addTargetParams(params)
 
 	return redeemTokens(params)
 }
 
 func redeemTokens(params url.Values) (OAuthAccessResponse, error) {
 	nothing := OAuthAccessResponse{}
+	addCustomParams(params, appConfig.TokenParams)
 
-	// Params as form-params in POST: https://golang.cafe/blog/how-to-make-http-url-form-encoded-request-golang.html
-	req, err := http.NewRequest(http.MethodPost, appConfig.TokenEndpoint, strings.NewReader(params.Encode()))
-	if err != nil {
-		return nothing, fmt.Errorf("could not create HTTP request to redeem tokens: %v", err)
-	}
-
-	req.Header.Set("content-type", "application/x-www-form-urlencoded")
-	req.Header.Set("accept", "application/json")
-
-	httpClient := http.Client{}
-	res, err := httpClient.Do(req)
+	res, err := postForm(appConfig.TokenEndpoint, params)
 	if err != nil {
 		return nothing, fmt.Errorf("could not send HTTP request to redeem tokens: %v", err)
 	}
@@ -268,6 +560,12 @@ func redeemTokens(params url.Values) (OAuthAccessResponse, error) {
 	}
 
 	bodyBytes, _ := io.ReadAll(res.Body)
+	if oauthErr := parseOAuthError(bodyBytes, res.StatusCode, params.Get("grant_type")); oauthErr != nil {
+		return nothing, oauthErr
+	}
+	if res.StatusCode >= 400 {
+		return nothing, fmt.Errorf("unexpected status code %v from token endpoint, raw body: %v", res.StatusCode, string(bodyBytes))
+	}
 	var tokens OAuthAccessResponse
 	if err := json.Unmarshal(bodyBytes, &tokens); err != nil {
 		return nothing, fmt.Errorf("could not parse JSON response for redeemed tokens: %v, raw body: %v", err, string(bodyBytes))
@@ -276,9 +574,110 @@ func redeemTokens(params url.Values) (OAuthAccessResponse, error) {
 	if len(tokens.AccessToken) == 0 {
 		return nothing, fmt.Errorf("no access token received, JSON response:\n%v", h.PrettyJson(string(bodyBytes)))
 	}
+	if err := checkAudience(tokens.AccessToken); err != nil {
+		return nothing, err
+	}
 	return tokens, nil
 }
 
+// POST form parameters to an IDP endpoint (with a DPoP proof if enabled)
+func postForm(endpoint string, params url.Values) (*http.Response, error) {
+	httpClient := http.Client{}
+	for attempt := 1; ; attempt++ {
+		// Params as form-params in POST: https://golang.cafe/blog/how-to-make-http-url-form-encoded-request-golang.html
+		req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(params.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("accept", "application/json")
+		if err := setDpopHeader(req, ""); err != nil {
+			return nil, err
+		}
+
+		res, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if attempt > 1 || !appConfig.Dpop || res.StatusCode != http.StatusBadRequest || !updateDpopNonce(req.URL, res) {
+			return res, nil
+		}
+		// The IDP requires a server provided nonce in the proof ("use_dpop_nonce"); try again (once)
+		res.Body.Close()
+	}
+}
+
+// Resource indicators, audiences and authorization details (if any) for the authorization
+// and token requests
+// 👉 https://datatracker.ietf.org/doc/html/rfc8707
+// 👉 https://datatracker.ietf.org/doc/html/rfc9396
+func addTargetParams(params url.Values) {
+	for _, resource := range appConfig.Resources {
+		params.Add("resource", resource)
+	}
+	for _, audience := range appConfig.Audiences {
+		params.Add("audience", audience)
+	}
+	if len(appConfig.AuthorizationDetails) > 0 {
+		params.Set("authorization_details", appConfig.AuthorizationDetails)
+	}
+}
+
+// Additional parameters (e.g. IDP specific extensions) replace standard ones with the same key
+func addCustomParams(params url.Values, custom url.Values) {
+	for key, values := range custom {
+		params[key] = values
+	}
+}
+
+// The "aud" claim is either a single string or an array
+func claimAudiences(claims h.Unstruct) []string {
+	audiences := []string{}
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = append(audiences, aud)
+	case []interface{}:
+		for _, value := range aud {
+			audiences = append(audiences, fmt.Sprintf("%v", value))
+		}
+	}
+	return audiences
+}
+
+// Reject (or with --allow-wrong-audience, warn about) an access token that isn't issued for
+// the requested resources/audiences (fail silent for opaque tokens, they can't be checked)
+func checkAudience(accessToken string) error {
+	expected := append(append([]string{}, appConfig.Resources...), appConfig.Audiences...)
+	if len(expected) == 0 {
+		return nil
+	}
+	claims, err := h.JwtClaims(accessToken)
+	if err != nil {
+		if appConfig.Verbose {
+			fmt.Printf("Could not check audience of (opaque?) access token: %v\n", err)
+		}
+		return nil
+	}
+
+	audiences := claimAudiences(claims)
+	for _, value := range expected {
+		found := false
+		for _, audience := range audiences {
+			if strings.TrimSuffix(audience, "/") == strings.TrimSuffix(value, "/") {
+				found = true
+			}
+		}
+		if !found {
+			description := fmt.Sprintf("access token audience %q doesn't include the requested %q", strings.Join(audiences, ", "), value)
+			if !appConfig.AllowWrongAudience {
+				return newOAuthError("audience_mismatch", description, "")
+			}
+			fmt.Fprintf(os.Stderr, "WARNING: %v\n", description)
+		}
+	}
+	return nil
+}
+
 func fetchUserInfo(accessToken string) (h.Unstruct, error) {
 	req, err := http.NewRequest(http.MethodGet, appConfig.UserInfoEndpoint, nil)
 	if err != nil {
@@ -286,7 +685,9 @@ func fetchUserInfo(accessToken string) (h.Unstruct, error) {
 	}
 
 	req.Header.Set("accept", "application/json")
-	req.Header.Add("Authorization", "Bearer "+accessToken)
+	if err := setAuthorizationHeader(req, accessToken); err != nil {
+		return nil, err
+	}
 
 	httpClient := http.Client{}
 	res, err := httpClient.Do(req)
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A profile is a named set of CLI settings (keyed by flag name, e.g. "client-id") that is
+// applied for flags that are specified neither on the command line nor via ENV.
+// The (optional) hosts are used by the credential helpers to map a server to a profile.
+//
+// Example profiles file:
+//
+//	{
+//	  "work": {
+//	    "hosts": ["git.example.com"],
+//	    "settings": {
+//	      "metadata-endpoint": "https://idp.example.com/.well-known/openid-configuration",
+//	      "client-id": "my-client"
+//	    }
+//	  }
+//	}
+type Profile struct {
+	Hosts    []string          `json:"hosts"`
+	Settings map[string]string `json:"settings"`
+}
+
+// The profiles file is located in the user's config directory unless overridden via ENV
+func profilesFilePath() string {
+	path := os.Getenv("O2TOKEN_PROFILES_FILE")
+	if len(path) > 0 {
+		return path
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, "o2token", "profiles.json")
+}
+
+func loadProfiles() (map[string]Profile, error) {
+	path := profilesFilePath()
+	if path == "" {
+		return nil, fmt.Errorf("could not determine location of profiles file")
+	}
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read profiles file: %v", err)
+	}
+	var profiles map[string]Profile
+	if err := json.Unmarshal(bytes, &profiles); err != nil {
+		return nil, fmt.Errorf("could not parse profiles file %v: %v", path, err)
+	}
+	return profiles, nil
+}
+
+// Apply the settings of a named profile to all flags not explicitly specified via CLI or ENV
+func applyProfile(fs *flag.FlagSet, name string) error {
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	profile, found := profiles[name]
+	if !found {
+		return fmt.Errorf("profile %v not defined in %v", name, profilesFilePath())
+	}
+
+	for key, value := range profile.Settings {
+		if key == "profile" {
+			return fmt.Errorf("invalid setting %v in profile %v", key, name)
+		}
+		if fs.Lookup(key) == nil {
+			continue // e.g. a setting only applicable to some other subcommand
+		}
+		if isFlagSpecified(fs, key) || len(os.Getenv(flagEnvVar(key))) > 0 {
+			continue
+		}
+		if err := fs.Set(key, value); err != nil {
+			return fmt.Errorf("invalid value for %v in profile %v: %v", key, name, err)
+		}
+	}
+	return nil
+}
+
+// Find the profile associated with a given host (optionally including a port)
+func profileForHost(host string) (string, bool) {
+	profiles, err := loadProfiles()
+	if err != nil || len(host) == 0 {
+		return "", false
+	}
+	hostname := strings.Split(host, ":")[0]
+
+	// Iterate in sorted order to get a deterministic result for ambiguous configurations
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, candidate := range profiles[name].Hosts {
+			if strings.EqualFold(candidate, host) || strings.EqualFold(candidate, hostname) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+func isFlagSpecified(fs *flag.FlagSet, name string) bool {
+	specified := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			specified = true
+		}
+	})
+	return specified
+}
+
+// Let a subcommand provide a value unless the user has already made a choice
+func setFlagUnlessSpecified(fs *flag.FlagSet, name string, value string) {
+	if !isFlagSpecified(fs, name) && len(os.Getenv(flagEnvVar(name))) == 0 {
+		fs.Set(name, value)
+	}
+}
+
+// The environment variable corresponding to a CLI flag, e.g. "client-id" -> "O2TOKEN_CLIENT_ID"
+func flagEnvVar(name string) string {
+	return "O2TOKEN_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Local reverse proxy that forwards all requests to an upstream API and authorizes them with
+// an access token (Bearer or DPoP). The token is kept fresh in the background and a rejected
+// token (401) triggers a refresh followed by one retry of the request.
+
+type ProxyTokenSource struct {
+	mutex     sync.Mutex
+	tokens    OAuthAccessResponse
+	expiresAt time.Time
+}
+
+type ProxyTransport struct {
+	source *ProxyTokenSource
+}
+
+func proxyCommand(args []string) error {
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	upstreamPtr := fs.String("upstream", parseStringEnvVar("", "O2TOKEN_UPSTREAM"), "Upstream API base URL to forward requests to")
+	listenPtr := fs.String("listen", parseStringEnvVar("127.0.0.1:8081", "O2TOKEN_LISTEN"), "Local address (and port) for the proxy")
+	refreshMarginPtr := fs.Duration("refresh-margin", 5*time.Minute, "Refresh the access token this long before it expires")
+
+	var err error
+	appConfig, err = initializeAppConfig(fs, args, nil)
+	if err != nil {
+		return fmt.Errorf("invalid/incomplete application configuration: %v", err)
+	}
+	upstream, err := url.Parse(*upstreamPtr)
+	if err != nil || len(upstream.Scheme) == 0 || len(upstream.Host) == 0 {
+		return fmt.Errorf("invalid/missing upstream URL: %q", *upstreamPtr)
+	}
+
+	tokens, err := acquireTokens()
+	if err != nil {
+		return fmt.Errorf("could not obtain access token: %w", err)
+	}
+	source := &ProxyTokenSource{tokens: tokens, expiresAt: tokenExpiry(tokens)}
+	go source.keepFresh(*refreshMarginPtr)
+
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Host = upstream.Host
+		req.Header.Del("Authorization") // replaced by ours
+	}
+	proxy.Transport = &ProxyTransport{source: source}
+
+	server := &http.Server{Addr: *listenPtr, Handler: proxy}
+	fmt.Fprintf(os.Stderr, "👉 Proxying http://%v -> %v\n", *listenPtr, upstream)
+	serveErrors, err := startServer(server)
+	if err != nil {
+		return err
+	}
+	return waitAndShutdown(server, serveErrors)
+}
+
+func (s *ProxyTokenSource) accessToken() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.tokens.AccessToken
+}
+
+// Renew the tokens unless the rejected token has already been replaced (by a concurrent request)
+func (s *ProxyTokenSource) renew(rejected string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.tokens.AccessToken != rejected {
+		return s.tokens.AccessToken, nil
+	}
+	tokens, err := renewTokens()
+	if err != nil {
+		return "", err
+	}
+	s.tokens = tokens
+	s.expiresAt = tokenExpiry(tokens)
+	if appConfig.Verbose {
+		fmt.Printf("Renewed access token (expires at %v)\n", s.expiresAt)
+	}
+	return tokens.AccessToken, nil
+}
+
+func (s *ProxyTokenSource) keepFresh(margin time.Duration) {
+	for {
+		s.mutex.Lock()
+		expiresAt := s.expiresAt
+		tokens := s.tokens
+		s.mutex.Unlock()
+		if time.Until(expiresAt) < effectiveRefreshMargin(tokens, margin) {
+			if _, err := s.renew(s.accessToken()); err != nil {
+				fmt.Fprintf(os.Stderr, "WARNING: could not refresh access token: %v\n", err)
+			}
+		}
+		time.Sleep(agentCheckInterval)
+	}
+}
+
+func (t *ProxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The body must be kept around in case the request has to be retried
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read request body: %v", err)
+		}
+	}
+
+	token := t.source.accessToken()
+	res, err := t.send(req, body, token)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	if appConfig.Dpop && updateDpopNonce(req.URL, res) {
+		if appConfig.Verbose {
+			fmt.Printf("Retrying %v %v with DPoP nonce\n", req.Method, req.URL)
+		}
+	} else {
+		renewed, err := t.source.renew(token)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: access token rejected and could not be refreshed: %v\n", err)
+			return res, nil
+		}
+		if appConfig.Verbose {
+			fmt.Printf("Retrying %v %v with refreshed access token\n", req.Method, req.URL)
+		}
+		token = renewed
+	}
+	res.Body.Close()
+	return t.send(req, body, token)
+}
+
+func (t *ProxyTransport) send(req *http.Request, body []byte, token string) (*http.Response, error) {
+	outReq := req.Clone(req.Context())
+	if body != nil {
+		outReq.Body = io.NopCloser(bytes.NewReader(body))
+		outReq.ContentLength = int64(len(body))
+	}
+	if err := setAuthorizationHeader(outReq, token); err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultTransport.RoundTrip(outReq)
+	if err == nil && appConfig.Verbose {
+		fmt.Printf("%v %v -> %v\n", req.Method, req.URL, res.Status)
+	}
+	return res, err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// OAuth 2.0 Protected Resource Metadata, i.e. how to find the authorization server (and the
+// scopes) for a resource given only its URL
+// 👉 https://datatracker.ietf.org/doc/html/rfc9728
+
+// Only a few fields defined here (the ones used by the app)
+type ProtectedResourceMetadata struct {
+	Resource             string   `json:"resource"`
+	AuthorizationServers []string `json:"authorization_servers"`
+	ScopesSupported      []string `json:"scopes_supported"`
+}
+
+const protectedResourceWellKnown = "/.well-known/oauth-protected-resource"
+
+// Find the metadata of a resource by trying (in order);
+// - the well-known location derived from the URL (with and without its path)
+// - the "resource_metadata" parameter of a 401 challenge from the URL itself
+//
+// The "resource" in the metadata must be the identifier the metadata was looked up for, i.e.
+// the URL the well-known location was derived from or the URL that was challenged (RFC 9728
+// section 3.3); otherwise the metadata may be an attempt to impersonate the resource
+func discoverProtectedResource(resourceUrl string, verbose bool) (ProtectedResourceMetadata, error) {
+	empty := ProtectedResourceMetadata{}
+	target, err := url.Parse(resourceUrl)
+	if err != nil || len(target.Scheme) == 0 || len(target.Host) == 0 {
+		return empty, fmt.Errorf("invalid resource URL: %q", resourceUrl)
+	}
+
+	type candidate struct {
+		metadataUrl string
+		resource    string
+	}
+	candidates := []candidate{}
+	path := strings.TrimSuffix(target.EscapedPath(), "/")
+	if len(path) > 0 {
+		candidates = append(candidates, candidate{origin(target) + protectedResourceWellKnown + path, origin(target) + path})
+	}
+	candidates = append(candidates, candidate{origin(target) + protectedResourceWellKnown, origin(target)}, candidate{"", resourceUrl})
+
+	for _, candidate := range candidates {
+		if candidate.metadataUrl == "" {
+			// Last resort (only probed when needed)
+			if candidate.metadataUrl = resourceMetadataFromChallenge(resourceUrl); candidate.metadataUrl == "" {
+				break
+			}
+		}
+		if verbose {
+			fmt.Printf("Looking for protected resource metadata at %v\n", candidate.metadataUrl)
+		}
+		metadata, err := fetchProtectedResourceMetadata(candidate.metadataUrl)
+		if err != nil {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+			continue
+		}
+		if len(metadata.Resource) == 0 {
+			return empty, fmt.Errorf("no resource identifier in %v", candidate.metadataUrl)
+		}
+		if normalizeResourceUrl(metadata.Resource) != normalizeResourceUrl(candidate.resource) {
+			return empty, fmt.Errorf("resource %q in %v doesn't match %v", metadata.Resource, candidate.metadataUrl, candidate.resource)
+		}
+		if len(metadata.AuthorizationServers) == 0 {
+			return empty, fmt.Errorf("no authorization servers listed in %v", candidate.metadataUrl)
+		}
+		return metadata, nil
+	}
+	return empty, fmt.Errorf("no protected resource metadata found for %v", resourceUrl)
+}
+
+// Case-insensitive scheme and host, without default port, fragment and trailing slash
+// (an unparsable URL is returned as it is, i.e. only matches itself)
+func normalizeResourceUrl(resourceUrl string) string {
+	u, err := url.Parse(resourceUrl)
+	if err != nil || len(u.Host) == 0 {
+		return resourceUrl
+	}
+	scheme, host := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname())
+	if port := u.Port(); len(port) > 0 && !(scheme == "https" && port == "443") && !(scheme == "http" && port == "80") {
+		host += ":" + port
+	}
+	normalized := scheme + "://" + host + strings.TrimSuffix(u.EscapedPath(), "/")
+	if len(u.RawQuery) > 0 {
+		normalized += "?" + u.RawQuery
+	}
+	return normalized
+}
+
+func fetchProtectedResourceMetadata(metadataUrl string) (ProtectedResourceMetadata, error) {
+	var metadata ProtectedResourceMetadata
+	req, err := http.NewRequest(http.MethodGet, metadataUrl, nil)
+	if err != nil {
+		return metadata, fmt.Errorf("could not create request for protected resource metadata: %v", err)
+	}
+	req.Header.Set("accept", "application/json")
+
+	httpClient := http.Client{}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return metadata, fmt.Errorf("could not send request for protected resource metadata: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return metadata, fmt.Errorf("unexpected status code for %v: %v", metadataUrl, res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&metadata); err != nil {
+		return metadata, fmt.Errorf("could not parse protected resource metadata: %v", err)
+	}
+	return metadata, nil
+}
+
+// Probe the resource without a token and look for a metadata reference in the challenge
+func resourceMetadataFromChallenge(resourceUrl string) string {
+	httpClient := http.Client{}
+	res, err := httpClient.Get(resourceUrl)
+	if err != nil {
+		return ""
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		return ""
+	}
+	challenges, _ := parseAuthChallenges(res.Header.Values("WWW-Authenticate"))
+	for _, challenge := range challenges {
+		if metadataUrl, found := challenge.Params["resource_metadata"]; found {
+			return metadataUrl
+		}
+	}
+	return ""
+}
+
+// The metadata document of an authorization server (issuer), either OIDC or RFC 8414 style
+func discoverMetadataEndpoint(issuer string) (string, error) {
+	issuerUrl, err := url.Parse(issuer)
+	if err != nil || len(issuerUrl.Host) == 0 {
+		return "", fmt.Errorf("invalid authorization server identifier: %q", issuer)
+	}
+	path := strings.TrimSuffix(issuerUrl.EscapedPath(), "/")
+	candidates := []string{
+		strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration",
+		origin(issuerUrl) + "/.well-known/oauth-authorization-server" + path,
+	}
+	httpClient := http.Client{}
+	for _, candidate := range candidates {
+		res, err := httpClient.Get(candidate)
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no metadata document found for authorization server %v", issuer)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+
+	h "o2token/helpers"
+)
+
+// Implicit and hybrid flows, i.e. response types where tokens are returned directly from the
+// authorization endpoint (mainly for reproducing the behavior of legacy apps)
+// 👉 https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html
+// 👉 https://openid.net/specs/openid-connect-core-1_0.html#HybridFlowAuth
+
+func isSupportedResponseType(responseType string) bool {
+	values := strings.Fields(responseType)
+	for _, value := range values {
+		if value != "code" && value != "id_token" && value != "token" {
+			return false
+		}
+	}
+	return len(values) > 0
+}
+
+func responseTypeIncludes(value string) bool {
+	for _, included := range strings.Fields(appConfig.ResponseType) {
+		if included == value {
+			return true
+		}
+	}
+	return false
+}
+
+// The default response mode is "fragment" when tokens are returned from the authorization endpoint
+// (also for the JARM "jwt" shorthand)
+func effectiveResponseMode() string {
+	switch {
+	case len(appConfig.ResponseMode) == 0 && appConfig.ResponseType != "code":
+		return "fragment"
+	case appConfig.ResponseMode == "jwt" && appConfig.ResponseType != "code":
+		return "fragment.jwt"
+	case appConfig.ResponseMode == "jwt":
+		return "query.jwt"
+	}
+	return appConfig.ResponseMode
+}
+
+// Tokens in the authorization response (nothing if only a code is expected)
+func frontChannelTokens(r *http.Request, code string) (OAuthAccessResponse, error) {
+	tokens := OAuthAccessResponse{
+		TokenType:   r.FormValue("token_type"),
+		Scope:       r.FormValue("scope"),
+		AccessToken: r.FormValue("access_token"),
+		IDToken:     r.FormValue("id_token"),
+	}
+	if expiresIn := r.FormValue("expires_in"); len(expiresIn) > 0 {
+		tokens.ExpiresIn, _ = strconv.Atoi(expiresIn)
+	}
+	if responseTypeIncludes("token") && len(tokens.AccessToken) == 0 {
+		return tokens, fmt.Errorf("missing 'access_token' parameter")
+	}
+	if !responseTypeIncludes("id_token") {
+		return tokens, nil
+	}
+	if len(tokens.IDToken) == 0 {
+		return tokens, fmt.Errorf("missing 'id_token' parameter")
+	}
+	return tokens, validateFrontChannelIdToken(tokens.IDToken, code, tokens.AccessToken)
+}
+
+// The nonce must match and the ID token must be bound to the code and access token (if any)
+// via their hashes ("c_hash" and "at_hash")
+func validateFrontChannelIdToken(idToken string, code string, accessToken string) error {
+	claims, err := h.JwtClaims(idToken)
+	if err != nil {
+		return fmt.Errorf("invalid ID token: %v", err)
+	}
+	if err := checkIdTokenNonce(claims); err != nil {
+		return err
+	}
+	alg, err := jwtAlgorithm(idToken)
+	if err != nil {
+		return err
+	}
+	if err := checkTokenHash(claims, "c_hash", code, alg); err != nil {
+		return err
+	}
+	return checkTokenHash(claims, "at_hash", accessToken, alg)
+}
+
+func checkIdTokenNonce(claims h.Unstruct) error {
+	if nonce, _ := claims["nonce"].(string); nonce != appConfig.Nonce {
+		return fmt.Errorf("unexpected nonce in ID token (expected: %v, got: %v)", appConfig.Nonce, nonce)
+	}
+	return nil
+}
+
+// An ID token from the token endpoint must carry the nonce of the authorization request (if any)
+func validateRedeemedIdToken(idToken string) error {
+	if len(idToken) == 0 || len(appConfig.Nonce) == 0 {
+		return nil
+	}
+	claims, err := h.JwtClaims(idToken)
+	if err != nil {
+		return fmt.Errorf("invalid ID token: %v", err)
+	}
+	return checkIdTokenNonce(claims)
+}
+
+// Access tokens are only returned for response types including "code" or "token"
+func responseTypeReturnsAccessToken() bool {
+	return responseTypeIncludes("code") || responseTypeIncludes("token")
+}
+
+func checkTokenHash(claims h.Unstruct, claim string, value string, alg string) error {
+	if len(value) == 0 {
+		return nil
+	}
+	claimed, found := claims[claim].(string)
+	if !found {
+		return fmt.Errorf("missing %q claim in ID token", claim)
+	}
+	computed, err := tokenHash(value, alg)
+	if err != nil {
+		return err
+	}
+	if computed != claimed {
+		return fmt.Errorf("%q claim in ID token doesn't match (expected: %v, got: %v)", claim, computed, claimed)
+	}
+	if appConfig.Verbose {
+		fmt.Printf("Verified %q claim in ID token\n", claim)
+	}
+	return nil
+}
+
+// Left-most half of the hash (the one used by the signature algorithm), base64url encoded
+func tokenHash(value string, alg string) (string, error) {
+	var hasher hash.Hash
+	switch {
+	case alg == "EdDSA" || strings.HasSuffix(alg, "512"):
+		hasher = sha512.New()
+	case strings.HasSuffix(alg, "384"):
+		hasher = sha512.New384()
+	case strings.HasSuffix(alg, "256"):
+		hasher = sha256.New()
+	default:
+		return "", fmt.Errorf("can't compute token hash for ID token algorithm %q", alg)
+	}
+	hasher.Write([]byte(value))
+	sum := hasher.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
+
+func jwtAlgorithm(jwt string) (string, error) {
+	headerBytes, err := h.Base64UrlDecode(strings.Split(jwt, ".")[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid JWT header: %v", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return "", fmt.Errorf("invalid JWT header: %v", err)
+	}
+	return header.Alg, nil
+}
+
+// Tokens from the token endpoint have precedence over those from the authorization endpoint
+func mergeTokens(redeemed OAuthAccessResponse, front OAuthAccessResponse) OAuthAccessResponse {
+	if len(redeemed.AccessToken) == 0 {
+		redeemed.AccessToken = front.AccessToken
+		redeemed.TokenType = front.TokenType
+		redeemed.ExpiresIn = front.ExpiresIn
+	}
+	if len(redeemed.IDToken) == 0 {
+		redeemed.IDToken = front.IDToken
+	}
+	if len(redeemed.Scope) == 0 {
+		redeemed.Scope = front.Scope
+	}
+	return redeemed
+}
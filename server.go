@@ -4,6 +4,7 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -17,12 +18,16 @@ var indexPage string
 var indexPath = "/"
 var loginPath = "/login"
 
+// Errors in a code flow started by runAuthCodeFlow are returned (and reported) by the caller
+var captureFlowError bool
+var flowError error
+
 // Graceful exit after server has been started
 var serverExit = func() {
 	os.Exit(1) // Placeholder until signal capturing has been configured
 }
 
-func serveAuthCodeFlow() {
+func serveAuthCodeFlow() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc(appConfig.CallbackPath, oauth2CodeCallback)
 	mux.HandleFunc(loginPath, startFlow)
@@ -33,15 +38,10 @@ func serveAuthCodeFlow() {
 	addrStr := fmt.Sprintf("%v%v", appConfig.Address, portStr)
 	server := &http.Server{Addr: addrStr, Handler: mux}
 
-	go func() {
-		if appConfig.Verbose {
-			fmt.Printf("Listen and serve at %v\n", addrStr)
-		}
-		serveErr := server.ListenAndServe()
-		if serveErr != http.ErrServerClosed {
-			fmt.Fprintf(os.Stderr, "ERROR: Unexpected error from HTTP server: %v\n", serveErr)
-		}
-	}()
+	serveErrors, err := startServer(server)
+	if err != nil {
+		return err
+	}
 
 	go func() {
 		manualLaunch := appConfig.NoBrowser || launchBrowser(loginUrlStr) != nil
@@ -50,6 +50,32 @@ func serveAuthCodeFlow() {
 		}
 	}()
 
+	return waitAndShutdown(server, serveErrors)
+}
+
+// Listen before serving in the background so that e.g. a port already in use is reported
+// right away; later errors of the server are delivered via the returned channel
+func startServer(server *http.Server) (<-chan error, error) {
+	if appConfig.Verbose {
+		fmt.Printf("Listen and serve at %v\n", server.Addr)
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not start HTTP server: %v", err)
+	}
+	serveErrors := make(chan error, 1)
+	go func() {
+		serveErr := server.Serve(listener)
+		if serveErr != http.ErrServerClosed {
+			serveErrors <- fmt.Errorf("unexpected error from HTTP server: %v", serveErr)
+		}
+	}()
+	return serveErrors, nil
+}
+
+// Block until a soft exit (or SIGINT), or a failure of the server, and then gracefully shut
+// down the server
+func waitAndShutdown(server *http.Server, serveErrors <-chan error) error {
 	// Setting up signal capturing and configure special exit-function
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
@@ -58,13 +84,51 @@ func serveAuthCodeFlow() {
 	}
 
 	// Waiting for SIGINT (kill -2)
-	<-stop
+	var serveErr error
+	select {
+	case <-stop:
+	case serveErr = <-serveErrors:
+	}
+	signal.Stop(stop)
+	if serveErr != nil {
+		return serveErr
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "Unexpected error when closing server: %v\n", err)
 	}
+	return nil
+}
+
+// Run the code flow and return the tokens instead of printing them (for callers that need an
+// access token, i.e. not for the ID token only response types)
+func runAuthCodeFlow() (OAuthAccessResponse, error) {
+	var result OAuthAccessResponse
+	if !responseTypeReturnsAccessToken() {
+		return result, fmt.Errorf("response type %q doesn't return an access token", appConfig.ResponseType)
+	}
+	captureFlowError, flowError = true, nil
+	codeFlowCompleted = func(tokens OAuthAccessResponse) error {
+		result = tokens
+		return nil
+	}
+	defer func() {
+		codeFlowCompleted = printTokens
+		captureFlowError = false
+	}()
+
+	if err := serveAuthCodeFlow(); err != nil {
+		return result, err
+	}
+	if flowError != nil {
+		return result, flowError
+	}
+	if exitCode != 0 || len(result.AccessToken) == 0 {
+		return result, fmt.Errorf("authorization code flow did not complete")
+	}
+	return result, nil
 }
 
 func serveEmbeddedPage(w http.ResponseWriter, r *http.Request) {
@@ -80,13 +144,20 @@ func serveString(value string, w http.ResponseWriter) {
 	w.Write(([]byte)(value))
 }
 
+// The code is the HTTP status of the response (if any) and the exit code unless the error
+// is an OAuth error, which has its own exit code
 func reportErrorAndSoftExit(label string, err error, code int, w http.ResponseWriter) {
-	msg := fmt.Sprintf("ERROR: %v: %v", label, err)
 	if w != nil {
-
 		w.WriteHeader(code)
-		w.Write(([]byte)(msg))
+		w.Write(([]byte)(fmt.Sprintf("ERROR: %v: %v", label, err)))
+	}
+	if captureFlowError {
+		flowError = fmt.Errorf("%v: %w", label, err)
+	} else {
+		reportError(label, err)
+	}
+	if errorExitCode(err) != 1 {
+		code = errorExitCode(err)
 	}
-	fmt.Fprintln(os.Stderr, msg)
 	softExit(code)
 }
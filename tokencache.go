@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	h "o2token/helpers"
+)
+
+// Cached access tokens are considered stale this long before they actually expire
+const tokenExpiryMargin = 60 * time.Second
+
+// Tokens are cached per profile, together with the settings they were obtained with
+// (a cache entry is ignored if e.g. the scope or the resource has been changed since)
+type CachedTokens struct {
+	ClientID             string              `json:"client_id"`
+	Scope                string              `json:"scope"`
+	TokenEndpoint        string              `json:"token_endpoint"`
+	Resources            []string            `json:"resources,omitempty"`
+	Audiences            []string            `json:"audiences,omitempty"`
+	AuthorizationDetails string              `json:"authorization_details,omitempty"`
+	Dpop                 bool                `json:"dpop,omitempty"`
+	ExpiresAt            time.Time           `json:"expires_at"`
+	Tokens               OAuthAccessResponse `json:"tokens"`
+}
+
+func tokenCachePath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine token cache location: %v", err)
+	}
+	name := appConfig.Profile
+	if name == "" {
+		name = "default"
+	}
+	return filepath.Join(cacheDir, "o2token", "tokens", name+".json"), nil
+}
+
+// Fail silent; a missing/broken/mismatching entry is just a cache miss
+func loadCachedTokens() (CachedTokens, bool) {
+	empty := CachedTokens{}
+	path, err := tokenCachePath()
+	if err != nil {
+		return empty, false
+	}
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return empty, false
+	}
+	var cached CachedTokens
+	if err := json.Unmarshal(bytes, &cached); err != nil {
+		if appConfig.Verbose {
+			fmt.Fprintf(os.Stderr, "Ignoring invalid token cache entry %v: %v\n", path, err)
+		}
+		return empty, false
+	}
+	if !cached.matchesAppConfig() {
+		return empty, false
+	}
+	return cached, true
+}
+
+// Tokens obtained for another client, scope, target API (resource/audience) or authorization
+// details must not be handed out for the current configuration, nor bearer tokens for DPoP
+// (or vice versa, the resource would reject them)
+func (c CachedTokens) matchesAppConfig() bool {
+	return c.ClientID == appConfig.ClientID &&
+		c.Scope == appConfig.Scope &&
+		c.TokenEndpoint == appConfig.TokenEndpoint &&
+		strings.Join(c.Resources, " ") == strings.Join(appConfig.Resources, " ") &&
+		strings.Join(c.Audiences, " ") == strings.Join(appConfig.Audiences, " ") &&
+		c.AuthorizationDetails == appConfig.AuthorizationDetails &&
+		c.Dpop == appConfig.Dpop
+}
+
+func storeCachedTokens(tokens OAuthAccessResponse) error {
+	return writeCachedTokens(CachedTokens{
+		ClientID:             appConfig.ClientID,
+		Scope:                appConfig.Scope,
+		TokenEndpoint:        appConfig.TokenEndpoint,
+		Resources:            appConfig.Resources,
+		Audiences:            appConfig.Audiences,
+		AuthorizationDetails: appConfig.AuthorizationDetails,
+		Dpop:                 appConfig.Dpop,
+		ExpiresAt:            tokenExpiry(tokens),
+		Tokens:               tokens,
+	})
+}
+
+func writeCachedTokens(cached CachedTokens) error {
+	path, err := tokenCachePath()
+	if err != nil {
+		return err
+	}
+	bytes, err := json.MarshalIndent(cached, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not format token cache entry: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("could not create token cache directory: %v", err)
+	}
+	if err := os.WriteFile(path, bytes, 0600); err != nil {
+		return fmt.Errorf("could not write token cache entry: %v", err)
+	}
+	return nil
+}
+
+// Mark the cached access token as expired but keep the refresh token (if any) for the next round
+func expireCachedTokens() error {
+	cached, found := loadCachedTokens()
+	if !found {
+		return nil
+	}
+	cached.ExpiresAt = time.Time{}
+	return writeCachedTokens(cached)
+}
+
+// Prefer the "exp" claim of the access token (if it is a JWT) over the "expires_in" field
+func tokenExpiry(tokens OAuthAccessResponse) time.Time {
+	claims, err := h.JwtClaims(tokens.AccessToken)
+	if err == nil {
+		if exp, ok := claims["exp"].(float64); ok {
+			return time.Unix(int64(exp), 0)
+		}
+	}
+	return time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+}
+
+// The lifetime of the access token as issued ("exp" - "iat" or "expires_in"), zero if unknown
+func tokenLifetime(tokens OAuthAccessResponse) time.Duration {
+	claims, err := h.JwtClaims(tokens.AccessToken)
+	if err == nil {
+		exp, hasExp := claims["exp"].(float64)
+		iat, hasIat := claims["iat"].(float64)
+		if hasExp && hasIat && exp > iat {
+			return time.Duration(exp-iat) * time.Second
+		}
+	}
+	return time.Duration(tokens.ExpiresIn) * time.Second
+}
+
+// Short-lived tokens would be refreshed on every check if the margin exceeded their lifetime
+func effectiveRefreshMargin(tokens OAuthAccessResponse, margin time.Duration) time.Duration {
+	lifetime := tokenLifetime(tokens)
+	if lifetime > 0 && margin > lifetime/2 {
+		return lifetime / 2
+	}
+	return margin
+}
+
+// Obtain tokens for the current configuration by trying (in order);
+// - a (still valid) cached access token
+// - a refresh token, either cached or configured
+// - a new client credentials or authorization code flow
+func acquireTokens() (OAuthAccessResponse, error) {
+	cached, found := loadCachedTokens()
+	if found && time.Until(cached.ExpiresAt) > tokenExpiryMargin {
+		if appConfig.Verbose {
+			fmt.Printf("Using cached access token (expires at %v)\n", cached.ExpiresAt)
+		}
+		return cached.Tokens, nil
+	}
+
+	refreshTokens := []string{}
+	if found && len(cached.Tokens.RefreshToken) > 0 {
+		refreshTokens = append(refreshTokens, cached.Tokens.RefreshToken)
+	}
+	if len(appConfig.RefreshToken) > 0 {
+		refreshTokens = append(refreshTokens, appConfig.RefreshToken)
+	}
+	for _, refreshToken := range refreshTokens {
+		tokens, err := redeemTokensWithRefreshToken(refreshToken)
+		if err == nil {
+			return cacheTokens(tokens, refreshToken), nil
+		}
+		if appConfig.Verbose {
+			fmt.Fprintf(os.Stderr, "Could not use refresh token: %v\n", err)
+		}
+	}
+
+	tokens, err := newTokens()
+	if err != nil {
+		return tokens, err
+	}
+	return cacheTokens(tokens, ""), nil
+}
+
+// Obtain new tokens without user interaction, i.e. via a refresh token or client credentials
+func renewTokens() (OAuthAccessResponse, error) {
+	refreshToken := appConfig.RefreshToken
+	if cached, found := loadCachedTokens(); found && len(cached.Tokens.RefreshToken) > 0 {
+		refreshToken = cached.Tokens.RefreshToken
+	}
+	if len(refreshToken) > 0 {
+		tokens, err := redeemTokensWithRefreshToken(refreshToken)
+		if err != nil {
+			return tokens, err
+		}
+		return cacheTokens(tokens, refreshToken), nil
+	}
+	if appConfig.ClientCredFlow {
+		tokens, err := redeemTokensWithClientCredentials()
+		if err != nil {
+			return tokens, err
+		}
+		return cacheTokens(tokens, ""), nil
+	}
+	return OAuthAccessResponse{}, fmt.Errorf("no refresh token available (interactive login required)")
+}
+
+// Run a new flow (i.e. without any refresh token) based on the current configuration
+func newTokens() (OAuthAccessResponse, error) {
+	if appConfig.ClientCredFlow {
+		return redeemTokensWithClientCredentials()
+	}
+	return runAuthCodeFlow()
+}
+
+// Store tokens in the cache, keeping the previous refresh token if the IDP didn't rotate it
+func cacheTokens(tokens OAuthAccessResponse, previousRefreshToken string) OAuthAccessResponse {
+	if len(tokens.RefreshToken) == 0 {
+		tokens.RefreshToken = previousRefreshToken
+	}
+	if err := storeCachedTokens(tokens); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: %v\n", err) // no show-stopper; the tokens are still usable
+	}
+	return tokens
+}
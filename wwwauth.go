@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Parsing of WWW-Authenticate challenges
+// 👉 https://datatracker.ietf.org/doc/html/rfc9110#section-11.6.1
+// 👉 https://datatracker.ietf.org/doc/html/rfc6750#section-3 (the "Bearer" scheme)
+
+type AuthChallenge struct {
+	Scheme  string
+	Token68 string
+	Params  map[string]string // parameter names are case-insensitive and stored in lower case
+}
+
+// Known error codes for the Bearer/DPoP schemes (RFC 6750, RFC 9449 and RFC 9470)
+var challengeErrorExplanations = map[string]string{
+	"invalid_request":                    "the request is malformed, e.g. a missing or duplicated parameter",
+	"invalid_token":                      "the access token is expired, revoked, malformed or issued for another resource",
+	"insufficient_scope":                 "the access token lacks the scope(s) required by the resource",
+	"insufficient_user_authentication":   "the authentication event behind the access token doesn't meet the resource's requirements (step-up authentication required)",
+	"invalid_dpop_proof":                 "the DPoP proof is invalid (wrong key, method, URL, timestamp or access token hash)",
+	"use_dpop_nonce":                     "the resource requires a server provided nonce in the DPoP proof",
+	"invalid_authorization_details":      "the authorization details of the access token are insufficient",
+	"insufficient_authorization_details": "the authorization details of the access token are insufficient",
+}
+
+// Parse all challenges from one or more WWW-Authenticate header values
+func parseAuthChallenges(headers []string) ([]AuthChallenge, error) {
+	challenges := []AuthChallenge{}
+	for _, header := range headers {
+		parsed, err := parseAuthChallengeHeader(header)
+		if err != nil {
+			return challenges, err
+		}
+		challenges = append(challenges, parsed...)
+	}
+	return challenges, nil
+}
+
+// Each comma separated item is either a new challenge ("scheme", "scheme token68" or
+// "scheme name=value") or another parameter ("name=value") of the current challenge
+func parseAuthChallengeHeader(header string) ([]AuthChallenge, error) {
+	challenges := []AuthChallenge{}
+	items, err := splitChallengeItems(header)
+	if err != nil {
+		return challenges, fmt.Errorf("%v in %q", err, header)
+	}
+	for _, item := range items {
+		scheme, rest := item, ""
+		space := strings.IndexAny(item, " \t")
+		equals := strings.IndexByte(item, '=')
+		if equals >= 0 && (space < 0 || equals < space || strings.HasPrefix(strings.TrimLeft(item[space:], " \t"), "=")) {
+			scheme, rest = "", item
+		} else if space >= 0 {
+			scheme, rest = item[:space], strings.TrimSpace(item[space+1:])
+		}
+
+		if len(scheme) > 0 {
+			challenges = append(challenges, AuthChallenge{Scheme: scheme, Params: map[string]string{}})
+		} else if len(challenges) == 0 {
+			return challenges, fmt.Errorf("parameter without scheme in %q", header)
+		}
+		if len(rest) == 0 {
+			continue
+		}
+
+		current := &challenges[len(challenges)-1]
+		name, value, isParam := strings.Cut(rest, "=")
+		name = strings.TrimSpace(name)
+		padding := len(scheme) > 0 && len(strings.TrimSpace(value)) == 0
+		if !isParam || padding || len(name) == 0 || strings.HasPrefix(value, "=") || strings.ContainsAny(name, " \t") {
+			current.Token68 = rest // e.g. "abc==" (trailing "=" is padding)
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "\"") {
+			value = unquoteChallengeValue(value)
+		}
+		current.Params[strings.ToLower(name)] = value
+	}
+	return challenges, nil
+}
+
+// Split on commas outside of quoted strings, skipping empty items
+func splitChallengeItems(header string) ([]string, error) {
+	items := []string{}
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i < len(header); i++ {
+		c := header[i]
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			if item := strings.TrimSpace(header[start:i]); len(item) > 0 {
+				items = append(items, item)
+			}
+			start = i + 1
+		}
+	}
+	if quoted {
+		return items, fmt.Errorf("unterminated quoted string")
+	}
+	if item := strings.TrimSpace(header[start:]); len(item) > 0 {
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func unquoteChallengeValue(quoted string) string {
+	var value strings.Builder
+	inner := strings.TrimSuffix(strings.TrimPrefix(quoted, "\""), "\"")
+	for i := 0; i < len(inner); i++ {
+		if inner[i] == '\\' && i+1 < len(inner) {
+			i++
+		}
+		value.WriteByte(inner[i])
+	}
+	return value.String()
+}
+
+// Human readable explanation of a challenge (one item per line)
+func explainChallenge(challenge AuthChallenge) string {
+	lines := []string{fmt.Sprintf("The resource accepts the %q authentication scheme", challenge.Scheme)}
+	if realm, found := challenge.Params["realm"]; found {
+		lines = append(lines, fmt.Sprintf("Realm: %v", realm))
+	}
+	if code, found := challenge.Params["error"]; found {
+		explanation, known := challengeErrorExplanations[code]
+		if !known {
+			explanation = "non-standard error code"
+		}
+		lines = append(lines, fmt.Sprintf("Error: %v (%v)", code, explanation))
+	} else if strings.EqualFold(challenge.Scheme, "Bearer") || strings.EqualFold(challenge.Scheme, "DPoP") {
+		lines = append(lines, "No error code; the request probably lacked (usable) credentials")
+	}
+	if description, found := challenge.Params["error_description"]; found {
+		lines = append(lines, fmt.Sprintf("Description: %v", description))
+	}
+	if uri, found := challenge.Params["error_uri"]; found {
+		lines = append(lines, fmt.Sprintf("More information: %v", uri))
+	}
+	if scope, found := challenge.Params["scope"]; found {
+		lines = append(lines, fmt.Sprintf("Required scope: %v (current: %v)", scope, appConfig.Scope))
+	}
+	if algs, found := challenge.Params["algs"]; found {
+		lines = append(lines, fmt.Sprintf("Accepted DPoP proof algorithms: %v", algs))
+	}
+
+	// Anything else (e.g. acr_values, max_age or resource_metadata) is listed as is
+	others := []string{}
+	for name, value := range challenge.Params {
+		switch name {
+		case "realm", "error", "error_description", "error_uri", "scope", "algs":
+		default:
+			others = append(others, fmt.Sprintf("%v: %v", name, value))
+		}
+	}
+	sort.Strings(others)
+	lines = append(lines, others...)
+	return strings.Join(lines, "\n")
+}
+
+// Find a challenge demanding step-up authentication
+// 👉 https://datatracker.ietf.org/doc/html/rfc9470#section-3
+func stepUpChallenge(res *http.Response) (AuthChallenge, bool) {
+	if res.StatusCode != http.StatusUnauthorized {
+		return AuthChallenge{}, false
+	}
+	challenges, _ := parseAuthChallenges(res.Header.Values("WWW-Authenticate"))
+	for _, challenge := range challenges {
+		if challenge.Params["error"] == "insufficient_user_authentication" {
+			return challenge, true
+		}
+	}
+	return AuthChallenge{}, false
+}
+
+// Run a new code flow with the authentication requirements of the challenge (and cache the result)
+func stepUpTokens(challenge AuthChallenge) (OAuthAccessResponse, error) {
+	if acrValues, found := challenge.Params["acr_values"]; found {
+		appConfig.AcrValues = acrValues
+	}
+	if maxAge, found := challenge.Params["max_age"]; found {
+		appConfig.MaxAge = maxAge
+	}
+	fmt.Fprintf(os.Stderr, "Step-up authentication required (acr_values: %q, max_age: %q); starting a new login flow\n", appConfig.AcrValues, appConfig.MaxAge)
+
+	// State, PKCE verifier and nonce are single-use, i.e. not to be reused from a previous flow
+	appConfig.State = genRandStr()
+	if appConfig.Pkce {
+		appConfig.CodeVerifier = genPkceCodeVerifier()
+		appConfig.CodeChallenge = computePkceCodeChallenge(appConfig.CodeVerifier)
+	}
+	if len(appConfig.Nonce) > 0 {
+		appConfig.Nonce = genRandStr()
+	}
+
+	tokens, err := runAuthCodeFlow()
+	if err != nil {
+		return tokens, err
+	}
+	return cacheTokens(tokens, ""), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAuthChallenges(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  []string
+		expected []AuthChallenge
+	}{
+		{
+			name:     "scheme only",
+			headers:  []string{"Negotiate"},
+			expected: []AuthChallenge{{Scheme: "Negotiate", Params: map[string]string{}}},
+		},
+		{
+			name:    "quoted and unquoted parameters",
+			headers: []string{`Bearer realm="example", error=invalid_token, error_description="The access token expired"`},
+			expected: []AuthChallenge{{Scheme: "Bearer", Params: map[string]string{
+				"realm": "example", "error": "invalid_token", "error_description": "The access token expired"}}},
+		},
+		{
+			name:     "escapes in quoted string",
+			headers:  []string{`Bearer realm="a \"quoted\" \\ realm"`},
+			expected: []AuthChallenge{{Scheme: "Bearer", Params: map[string]string{"realm": `a "quoted" \ realm`}}},
+		},
+		{
+			name:    "comma in quoted string",
+			headers: []string{`Bearer error="insufficient_scope", scope="read, write"`},
+			expected: []AuthChallenge{{Scheme: "Bearer", Params: map[string]string{
+				"error": "insufficient_scope", "scope": "read, write"}}},
+		},
+		{
+			name:     "case-insensitive parameter names and whitespace around equals sign",
+			headers:  []string{`Bearer Realm = "api"`},
+			expected: []AuthChallenge{{Scheme: "Bearer", Params: map[string]string{"realm": "api"}}},
+		},
+		{
+			name:     "token68",
+			headers:  []string{"Negotiate YIIBhwYGKwYBBQUCoIIBezCCAXeg"},
+			expected: []AuthChallenge{{Scheme: "Negotiate", Token68: "YIIBhwYGKwYBBQUCoIIBezCCAXeg", Params: map[string]string{}}},
+		},
+		{
+			name:     "token68 with padding",
+			headers:  []string{"Basic dXNlcjpwYXNz=="},
+			expected: []AuthChallenge{{Scheme: "Basic", Token68: "dXNlcjpwYXNz==", Params: map[string]string{}}},
+		},
+		{
+			name:    "several challenges in one header",
+			headers: []string{`Basic realm="legacy", Bearer realm="api", error="invalid_token", Negotiate`},
+			expected: []AuthChallenge{
+				{Scheme: "Basic", Params: map[string]string{"realm": "legacy"}},
+				{Scheme: "Bearer", Params: map[string]string{"realm": "api", "error": "invalid_token"}},
+				{Scheme: "Negotiate", Params: map[string]string{}},
+			},
+		},
+		{
+			name:    "several headers",
+			headers: []string{`Bearer error="insufficient_user_authentication", acr_values="mfa"`, `DPoP algs="ES256 PS256"`},
+			expected: []AuthChallenge{
+				{Scheme: "Bearer", Params: map[string]string{"error": "insufficient_user_authentication", "acr_values": "mfa"}},
+				{Scheme: "DPoP", Params: map[string]string{"algs": "ES256 PS256"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			challenges, err := parseAuthChallenges(test.headers)
+			if err != nil {
+				t.Fatalf("parsing failed: %v", err)
+			}
+			if !reflect.DeepEqual(challenges, test.expected) {
+				t.Errorf("unexpected challenges %+v (expected: %+v)", challenges, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseAuthChallengesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"unterminated quoted string", `Bearer realm="api`},
+		{"parameter without scheme", `realm="api", Bearer`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parseAuthChallenges([]string{test.header}); err == nil {
+				t.Errorf("invalid header %q accepted", test.header)
+			}
+		})
+	}
+}